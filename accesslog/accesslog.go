@@ -0,0 +1,56 @@
+// Package accesslog writes one JSON object per finished flow.
+package accesslog
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Record is an access log record of a finished TCP connection or UDP session.
+type Record struct {
+	Time time.Time `json:"ts"`
+	// Proto is "tcp" or "udp".
+	Proto string `json:"proto"`
+	// Protocol is the sniffed application protocol, e.g. "tls".
+	Protocol string `json:"protocol,omitempty"`
+	Src      string `json:"src"`
+	Dst      string `json:"dst"`
+	// Domain is the sniffed host, or the domain resolved from the fake ip
+	// address or snooped DNS responses.
+	Domain string `json:"domain,omitempty"`
+	// Rule and Handler are the matched rule and the handler of the flow.
+	Rule      string `json:"rule,omitempty"`
+	Handler   string `json:"handler,omitempty"`
+	BytesUp   uint64 `json:"bytes_up"`
+	BytesDown uint64 `json:"bytes_down"`
+	// Duration is in seconds.
+	Duration float64 `json:"duration"`
+	Reason   string  `json:"reason,omitempty"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// Logger writes the records as JSON lines to an io.Writer, it's safe for
+// concurrent use.
+type Logger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func New(w io.Writer) *Logger {
+	return &Logger{w: w}
+}
+
+// Log writes rec as a single line.
+func (l *Logger) Log(rec Record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.w.Write(b)
+	return err
+}
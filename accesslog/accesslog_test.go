@@ -0,0 +1,113 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf)
+	for i := 0; i < 2; i++ {
+		if err := l.Log(Record{Time: time.Unix(0, 0), Proto: "tcp", Src: "198.18.0.1:50000", Dst: "1.1.1.1:443", BytesUp: 10, Duration: 1.5}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines", len(lines))
+	}
+	var m map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["proto"] != "tcp" || m["bytes_up"] != 10.0 || m["duration"] != 1.5 {
+		t.Fatalf("unexpected record %v", m)
+	}
+	if _, ok := m["error"]; ok {
+		t.Fatal("empty error is not omitted")
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, line := range []string{"aaaaaa\n", "bbbbbb\n", "cccccc\n", "dddddd\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, want := range map[string]string{
+		path:        "dddddd\n",
+		path + ".1": "cccccc\n",
+		path + ".2": "bbbbbb\n",
+	} {
+		if got := readFile(t, name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("unexpected backup: %v", err)
+	}
+}
+
+func TestRotatingFileRenameFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	// a non-empty directory can't be replaced by the rotated file
+	if err := os.MkdirAll(filepath.Join(path+".1", "dir"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := OpenRotatingFile(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.Write([]byte("aaaaaa\n")); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := f.Write([]byte("bbbbbb\n")); err == nil || n != 7 {
+		t.Fatalf("unexpected write %d, %v with a failed rotation", n, err)
+	}
+	if got := readFile(t, path); got != "aaaaaa\nbbbbbb\n" {
+		t.Fatalf("%s = %q after a failed rotation", path, got)
+	}
+
+	// the rotation succeeds once the backup can be replaced
+	if err = os.RemoveAll(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write([]byte("cccccc\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, path); got != "cccccc\n" {
+		t.Errorf("%s = %q", path, got)
+	}
+	if got := readFile(t, path+".1"); got != "aaaaaa\nbbbbbb\n" {
+		t.Errorf("%s.1 = %q", path, got)
+	}
+
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write([]byte("x")); err != os.ErrClosed {
+		t.Fatalf("unexpected write error %v after Close", err)
+	}
+}
+
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,115 @@
+package accesslog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is an io.WriteCloser appending to a file, which is rotated
+// before it grows larger than the max size. The rotated files are named
+// path.1, path.2, ... from the newest to the oldest.
+type RotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	closed     bool
+}
+
+// OpenRotatingFile opens or creates the file at path, keeping at most
+// maxBackups rotated files. The file isn't rotated if maxSize <= 0.
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// Write appends b to the file, the file is rotated first if b doesn't fit.
+// A single b is never split across files. If the rotation fails, b is still
+// appended to the current file and the rotation error is returned.
+func (f *RotatingFile) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	var rotateErr error
+	if f.file != nil && f.maxSize > 0 && f.size > 0 && f.size+int64(len(b)) > f.maxSize {
+		rotateErr = f.rotate()
+	}
+	// the file is nil if a rotation failed to reopen it
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, errors.Join(rotateErr, err)
+		}
+	}
+	n, err := f.file.Write(b)
+	f.size += int64(n)
+	if rotateErr != nil {
+		return n, errors.Join(fmt.Errorf("rotate %s: %w", f.path, rotateErr), err)
+	}
+	return n, err
+}
+
+// rotate moves the file to the first backup and opens a new one. The file
+// at path is reopened for appending if it can't be moved.
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err == nil {
+		err = f.shift()
+	}
+	return errors.Join(err, f.open())
+}
+
+// shift renames the backups and the file, or removes the file if no backup
+// is kept.
+func (f *RotatingFile) shift() error {
+	if f.maxBackups <= 0 {
+		return os.Remove(f.path)
+	}
+	for i := f.maxBackups - 1; i > 0; i-- {
+		// the missing backups are fine
+		_ = os.Rename(f.backup(i), f.backup(i+1))
+	}
+	return os.Rename(f.path, f.backup(1))
+}
+
+func (f *RotatingFile) backup(i int) string {
+	return fmt.Sprintf("%s.%d", f.path, i)
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
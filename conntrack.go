@@ -0,0 +1,266 @@
+package netstackgo
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/josexy/netstackgo/sniff"
+	"github.com/josexy/netstackgo/tun/core/adapter"
+)
+
+// ConnInfo is the snapshot of an active connection in the connection table.
+type ConnInfo struct {
+	ID      uint64
+	Network string // "tcp" or "udp"
+	Tuple   ConnTuple
+	Start   time.Time
+	// BytesUp and BytesDown are the bytes read from and written to the
+	// TUN client so far.
+	BytesUp   uint64
+	BytesDown uint64
+	// TCPInfo is the live state of a TCP connection, it's nil for UDP
+	// sessions.
+	TCPInfo *adapter.TCPInfo
+}
+
+type trackedConn struct {
+	id      uint64
+	network string
+	tuple   ConnTuple
+	start   time.Time
+	tcpConn adapter.TCPConn
+	up      atomic.Uint64
+	down    atomic.Uint64
+	// err is the first read or write error of the connection.
+	err   atomic.Pointer[error]
+	route atomic.Pointer[connRoute]
+}
+
+// connRoute is the rule and the handler a connection was dispatched to.
+type connRoute struct {
+	rule    string
+	handler string
+}
+
+// dnsHijackRoute is the rule and the handler name of the DNS queries
+// answered by the resolver of WithDNSHijack.
+const dnsHijackRoute = "dns-hijack"
+
+// routeRecorder is implemented by the conns passed to ConnHandler, so that
+// Router can record the matched rule.
+type routeRecorder interface {
+	setRoute(rule string, handler any)
+}
+
+func (c *trackedConn) setRoute(rule string, handler any) {
+	c.route.Store(&connRoute{rule: rule, handler: fmt.Sprintf("%T", handler)})
+}
+
+// setHijacked records that the connection is answered by the DNS hijack.
+func (c *trackedConn) setHijacked() {
+	c.route.Store(&connRoute{rule: dnsHijackRoute, handler: dnsHijackRoute})
+}
+
+func (c *trackedConn) getRoute() connRoute {
+	if r := c.route.Load(); r != nil {
+		return *r
+	}
+	return connRoute{}
+}
+
+func (c *trackedConn) recordError(err error) {
+	if err != nil {
+		c.err.CompareAndSwap(nil, &err)
+	}
+}
+
+// closeReason returns why the connection was closed by the first error.
+func (c *trackedConn) closeReason() (CloseReason, error) {
+	p := c.err.Load()
+	if p == nil {
+		return ReasonClosed, nil
+	}
+	err := *p
+	var netErr net.Error
+	switch {
+	case errors.Is(err, io.EOF):
+		return ReasonEOF, nil
+	case errors.Is(err, net.ErrClosed):
+		return ReasonClosed, nil
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ReasonTimeout, err
+	default:
+		return ReasonError, err
+	}
+}
+
+// countingTCPConn counts the bytes of a tracked TCP connection.
+type countingTCPConn struct {
+	adapter.TCPConn
+	tracked *trackedConn
+}
+
+func (c *countingTCPConn) setRoute(rule string, handler any) {
+	c.tracked.setRoute(rule, handler)
+}
+
+func (c *countingTCPConn) Read(b []byte) (int, error) {
+	n, err := c.TCPConn.Read(b)
+	c.tracked.recordError(err)
+	c.tracked.up.Add(uint64(n))
+	return n, err
+}
+
+func (c *countingTCPConn) Write(b []byte) (int, error) {
+	n, err := c.TCPConn.Write(b)
+	c.tracked.recordError(err)
+	c.tracked.down.Add(uint64(n))
+	return n, err
+}
+
+// Protocol implements ProtocolConn, it falls back to the protocol labeled
+// in the tuple if the underlying conn isn't sniffed.
+func (c *countingTCPConn) Protocol() sniff.Protocol {
+	if pc, ok := c.TCPConn.(ProtocolConn); ok {
+		return pc.Protocol()
+	}
+	return c.tracked.tuple.Protocol
+}
+
+// countingUDPConn counts the bytes of a tracked UDP session.
+type countingUDPConn struct {
+	adapter.UDPConn
+	tracked *trackedConn
+}
+
+func (c *countingUDPConn) setRoute(rule string, handler any) {
+	c.tracked.setRoute(rule, handler)
+}
+
+func (c *countingUDPConn) Read(b []byte) (int, error) {
+	n, err := c.UDPConn.Read(b)
+	c.tracked.recordError(err)
+	c.tracked.up.Add(uint64(n))
+	return n, err
+}
+
+func (c *countingUDPConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.UDPConn.ReadFrom(b)
+	c.tracked.recordError(err)
+	c.tracked.up.Add(uint64(n))
+	return n, addr, err
+}
+
+func (c *countingUDPConn) Write(b []byte) (int, error) {
+	n, err := c.UDPConn.Write(b)
+	c.tracked.recordError(err)
+	c.tracked.down.Add(uint64(n))
+	return n, err
+}
+
+func (c *countingUDPConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := c.UDPConn.WriteTo(b, addr)
+	c.tracked.recordError(err)
+	c.tracked.down.Add(uint64(n))
+	return n, err
+}
+
+// connTotals is the cumulative counters of the connections of a network.
+type connTotals struct {
+	opened    uint64
+	bytesUp   uint64
+	bytesDown uint64
+	durations *histogram
+}
+
+// connTable keeps track of the connections being handled by ConnHandler.
+type connTable struct {
+	mu     sync.RWMutex
+	nextID uint64
+	conns  map[uint64]*trackedConn
+	// totals is keyed by network, the bytes of the active connections
+	// aren't included.
+	totals map[string]*connTotals
+}
+
+func newConnTable() *connTable {
+	t := &connTable{
+		conns:  make(map[uint64]*trackedConn),
+		totals: make(map[string]*connTotals),
+	}
+	for _, network := range []string{"tcp", "udp"} {
+		t.totals[network] = &connTotals{durations: newHistogram(durationBuckets)}
+	}
+	return t
+}
+
+// addTCP tracks conn and returns the conn counting its bytes.
+func (t *connTable) addTCP(connTuple ConnTuple, conn adapter.TCPConn) (*trackedConn, adapter.TCPConn) {
+	c := t.add(&trackedConn{network: "tcp", tuple: connTuple, tcpConn: conn})
+	return c, &countingTCPConn{TCPConn: conn, tracked: c}
+}
+
+// addUDP tracks conn and returns the conn counting its bytes.
+func (t *connTable) addUDP(connTuple ConnTuple, conn adapter.UDPConn) (*trackedConn, adapter.UDPConn) {
+	c := t.add(&trackedConn{network: "udp", tuple: connTuple})
+	return c, &countingUDPConn{UDPConn: conn, tracked: c}
+}
+
+func (t *connTable) add(c *trackedConn) *trackedConn {
+	c.start = time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nextID++
+	c.id = t.nextID
+	t.conns[c.id] = c
+	t.totals[c.network].opened++
+	return c
+}
+
+func (t *connTable) remove(c *trackedConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.conns, c.id)
+	totals := t.totals[c.network]
+	totals.bytesUp += c.up.Load()
+	totals.bytesDown += c.down.Load()
+	totals.durations.observe(time.Since(c.start).Seconds())
+}
+
+func (t *connTable) snapshot() []ConnInfo {
+	t.mu.RLock()
+	conns := make([]*trackedConn, 0, len(t.conns))
+	for _, c := range t.conns {
+		conns = append(conns, c)
+	}
+	t.mu.RUnlock()
+
+	infos := make([]ConnInfo, 0, len(conns))
+	for _, c := range conns {
+		info := ConnInfo{
+			ID:        c.id,
+			Network:   c.network,
+			Tuple:     c.tuple,
+			Start:     c.start,
+			BytesUp:   c.up.Load(),
+			BytesDown: c.down.Load(),
+		}
+		if c.tcpConn != nil {
+			if tcpInfo, err := c.tcpConn.TCPInfo(); err == nil {
+				info.TCPInfo = &tcpInfo
+			}
+		}
+		infos = append(infos, info)
+	}
+	slices.SortFunc(infos, func(a, b ConnInfo) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return infos
+}
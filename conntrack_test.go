@@ -0,0 +1,66 @@
+package netstackgo
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/josexy/netstackgo/tun/core/adapter"
+)
+
+// infoTCPConn is a pipeTCPConn reporting a fixed TCPInfo.
+type infoTCPConn struct {
+	pipeTCPConn
+	info adapter.TCPInfo
+}
+
+func (c infoTCPConn) TCPInfo() (adapter.TCPInfo, error) { return c.info, nil }
+
+func TestConnTableSnapshot(t *testing.T) {
+	table := newConnTable()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	tcpConn := infoTCPConn{pipeTCPConn: pipeTCPConn{Conn: server}, info: adapter.TCPInfo{State: "ESTABLISHED"}}
+	tracked, conn := table.addTCP(ConnTuple{}, tcpConn)
+	go func() {
+		client.Write([]byte("hello"))
+		io.ReadFull(client, make([]byte, 2))
+	}()
+	if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the IDs are far apart, so that their difference overflows int
+	table.nextID = 1<<63 + 1
+	table.addUDP(ConnTuple{}, nil)
+
+	infos := table.snapshot()
+	if len(infos) != 2 {
+		t.Fatalf("unexpected %d connections", len(infos))
+	}
+	if infos[0].ID != tracked.id || infos[1].ID != 1<<63+2 {
+		t.Fatalf("unordered IDs %d, %d", infos[0].ID, infos[1].ID)
+	}
+	tcpInfo := infos[0]
+	if tcpInfo.Network != "tcp" || tcpInfo.BytesUp != 5 || tcpInfo.BytesDown != 2 {
+		t.Errorf("unexpected tcp connection %+v", tcpInfo)
+	}
+	if tcpInfo.TCPInfo == nil || tcpInfo.TCPInfo.State != "ESTABLISHED" {
+		t.Errorf("unexpected tcp info %+v", tcpInfo.TCPInfo)
+	}
+	if infos[1].Network != "udp" || infos[1].TCPInfo != nil {
+		t.Errorf("unexpected udp session %+v", infos[1])
+	}
+
+	table.remove(tracked)
+	if infos = table.snapshot(); len(infos) != 1 || infos[0].Network != "udp" {
+		t.Fatalf("unexpected connections %+v after remove", infos)
+	}
+	if totals := table.totals["tcp"]; totals.opened != 1 || totals.bytesUp != 5 || totals.bytesDown != 2 {
+		t.Errorf("unexpected tcp totals %+v", totals)
+	}
+}
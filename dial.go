@@ -0,0 +1,101 @@
+package netstackgo
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+)
+
+// DialContext connects from the netstack to the address on the TUN side,
+// e.g. a client behind the TUN. The source address is the last usable
+// address of the TUN subnet, not the address of the TUN device, because the
+// host drops the packets from its own address as martian. Use
+// DialContextFrom to pick any other source address. The network must be
+// "tcp", "tcp4", "tcp6", "udp", "udp4" or "udp6", and address must be a
+// literal ip:port.
+func (ns *TunNetstack) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	raddr, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	laddr, err := ns.dialSource(raddr.Addr())
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	return ns.DialContextFrom(ctx, network, netip.AddrPortFrom(laddr, 0), raddr)
+}
+
+// dialSource returns the default source address of DialContext to raddr.
+func (ns *TunNetstack) dialSource(raddr netip.Addr) (netip.Addr, error) {
+	prefix, err := netip.ParsePrefix(ns.tunCfg.Addr)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if prefix.Addr().Is4() != raddr.Unmap().Is4() {
+		return netip.Addr{}, fmt.Errorf("no source address for %s", raddr)
+	}
+	// the last address of an IPv4 subnet is the broadcast address
+	last := lastAddr(prefix.Masked())
+	if last.Is4() {
+		last = last.Prev()
+	}
+	if !prefix.Contains(last) || last == prefix.Addr() || last == prefix.Masked().Addr() {
+		return netip.Addr{}, fmt.Errorf("no spare source address in %s", prefix)
+	}
+	return last, nil
+}
+
+// lastAddr returns the last address of prefix.
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().AsSlice()
+	for i := prefix.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 1 << (7 - i%8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// DialContextFrom connects from laddr to raddr like DialContext. The laddr
+// is not required to be owned by the netstack, and the port of laddr is
+// picked by the netstack if zero.
+func (ns *TunNetstack) DialContextFrom(ctx context.Context, network string, laddr, raddr netip.AddrPort) (net.Conn, error) {
+	if !ns.running {
+		return nil, errNotRunning
+	}
+	if laddr.Addr().Unmap().Is4() != raddr.Addr().Unmap().Is4() {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: fmt.Errorf("mismatched address families %s and %s", laddr.Addr(), raddr.Addr())}
+	}
+	if err := checkNetwork(network, raddr.Addr()); err != nil {
+		return nil, err
+	}
+	localAddr, proto := fullAddress(laddr)
+	remoteAddr, _ := fullAddress(raddr)
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return gonet.DialTCPWithBind(ctx, ns.netstack, localAddr, remoteAddr, proto)
+	default:
+		return gonet.DialUDP(ns.netstack, &localAddr, &remoteAddr, proto)
+	}
+}
+
+func checkNetwork(network string, addr netip.Addr) error {
+	is4 := addr.Unmap().Is4()
+	switch network {
+	case "tcp", "udp":
+		return nil
+	case "tcp4", "udp4":
+		if is4 {
+			return nil
+		}
+	case "tcp6", "udp6":
+		if !is4 {
+			return nil
+		}
+	default:
+		return net.UnknownNetworkError(network)
+	}
+	return &net.OpError{Op: "dial", Net: network, Err: &net.AddrError{Err: "mismatched address family", Addr: addr.String()}}
+}
@@ -0,0 +1,183 @@
+package netstackgo
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/josexy/netstackgo/tun"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// listenClient listens on port of the TUN client stack s.
+func listenClient(t *testing.T, s *stack.Stack, port uint16) *gonet.TCPListener {
+	ln, err := gonet.ListenTCP(s, tcpip.FullAddress{NIC: 1, Addr: tcpip.AddrFrom4(testClientAddr.As4()), Port: port}, ipv4.ProtocolNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	return ln
+}
+
+// acceptRemote accepts a connection from ln and returns its remote address.
+func acceptRemote(t *testing.T, ln *gonet.TCPListener) netip.AddrPort {
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	select {
+	case conn := <-accepted:
+		defer conn.Close()
+		return netip.MustParseAddrPort(conn.RemoteAddr().String())
+	case <-time.After(5 * time.Second):
+		t.Fatal("no connection was accepted")
+		return netip.AddrPort{}
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	ns, client := startTestNetstack(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// the host drops the packets from its own address 198.18.0.1
+	source := netip.MustParseAddr("198.18.255.254")
+
+	ln := listenClient(t, client, 8080)
+	conn, err := ns.DialContext(ctx, "tcp", netip.AddrPortFrom(testClientAddr, 8080).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if remote := acceptRemote(t, ln); remote.Addr() != source {
+		t.Fatalf("unexpected source address %s", remote)
+	}
+
+	pc, err := gonet.DialUDP(client, &tcpip.FullAddress{NIC: 1, Addr: tcpip.AddrFrom4(testClientAddr.As4()), Port: 5353}, nil, ipv4.ProtocolNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	udpConn, err := ns.DialContext(ctx, "udp4", netip.AddrPortFrom(testClientAddr, 5353).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpConn.Close()
+	if _, err = udpConn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 16)
+	n, from, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "ping" || netip.MustParseAddrPort(from.String()).Addr() != source {
+		t.Fatalf("unexpected datagram %q from %s", buf[:n], from)
+	}
+}
+
+func TestDialContextFromSpoofed(t *testing.T) {
+	ns, client := startTestNetstack(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ln := listenClient(t, client, 8080)
+	spoofed := netip.MustParseAddrPort("1.2.3.4:4321")
+	conn, err := ns.DialContextFrom(ctx, "tcp4", spoofed, netip.AddrPortFrom(testClientAddr, 8080))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if remote := acceptRemote(t, ln); remote != spoofed {
+		t.Fatalf("the peer sees %s, want %s", remote, spoofed)
+	}
+}
+
+func TestDialContextErrors(t *testing.T) {
+	ns, _ := startTestNetstack(t)
+	ctx := context.Background()
+	v4 := netip.AddrPortFrom(testClientAddr, 80)
+	v6 := netip.MustParseAddrPort("[fd00::2]:80")
+
+	for _, tt := range []struct {
+		name string
+		dial func() error
+	}{
+		{"no ipv6 source", func() error {
+			_, err := ns.DialContext(ctx, "tcp", v6.String())
+			return err
+		}},
+		{"not a literal address", func() error {
+			_, err := ns.DialContext(ctx, "tcp", "example.com:80")
+			return err
+		}},
+		{"mismatched families", func() error {
+			_, err := ns.DialContextFrom(ctx, "tcp", netip.MustParseAddrPort("[fd00::1]:0"), v4)
+			return err
+		}},
+		{"mismatched network", func() error {
+			_, err := ns.DialContext(ctx, "udp6", v4.String())
+			return err
+		}},
+		{"unknown network", func() error {
+			_, err := ns.DialContext(ctx, "sctp", v4.String())
+			var unknown net.UnknownNetworkError
+			if !errors.As(err, &unknown) {
+				t.Errorf("unexpected error %v", err)
+			}
+			return err
+		}},
+	} {
+		if err := tt.dial(); err == nil {
+			t.Errorf("%s: dial succeeded", tt.name)
+		}
+	}
+
+	if err := ns.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ns.DialContext(ctx, "tcp", v4.String()); !errors.Is(err, errNotRunning) {
+		t.Fatalf("unexpected error %v after Close", err)
+	}
+	ns = New(tun.TunConfig{Addr: "198.18.0.1/16", MTU: 1500, SkipSetup: true})
+	if _, err := ns.DialContext(ctx, "tcp", v4.String()); !errors.Is(err, errNotRunning) {
+		t.Fatalf("unexpected error %v before Start", err)
+	}
+}
+
+func TestDialSource(t *testing.T) {
+	for _, tt := range []struct {
+		addr string
+		want string
+	}{
+		{"198.18.0.1/16", "198.18.255.254"},
+		{"10.0.0.1/30", "10.0.0.2"},
+		{"fd00::1/64", "fd00::ffff:ffff:ffff:ffff"},
+		{"10.0.0.1/32", ""},
+		{"10.0.0.1/31", ""},
+		// the last usable address is the TUN address itself
+		{"10.0.0.2/30", ""},
+	} {
+		ns := New(tun.TunConfig{Addr: tt.addr})
+		prefix := netip.MustParsePrefix(tt.addr)
+		got, err := ns.dialSource(prefix.Addr())
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: unexpected source %s", tt.addr, got)
+			}
+			continue
+		}
+		if err != nil || got != netip.MustParseAddr(tt.want) {
+			t.Errorf("%s: source %s, %v, want %s", tt.addr, got, err, tt.want)
+		}
+	}
+}
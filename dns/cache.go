@@ -0,0 +1,164 @@
+package dns
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// defaultCacheSize is the maximum number of cached responses.
+	defaultCacheSize = 4096
+
+	// negativeCacheTTL is the TTL of the cached responses without any
+	// answer, e.g. NXDOMAIN.
+	negativeCacheTTL = 30
+)
+
+type cacheKey struct {
+	name  string
+	qtype dnsmessage.Type
+	class dnsmessage.Class
+}
+
+type cacheEntry struct {
+	key    cacheKey
+	msg    dnsmessage.Message
+	stored time.Time
+	expire time.Time
+}
+
+// Cache caches the responses of the next resolver until the minimum TTL
+// of their records expires. The least recently used response is dropped
+// when the cache is full.
+type Cache struct {
+	mu      sync.Mutex
+	size    int
+	lru     *list.List
+	entries map[cacheKey]*list.Element
+	next    Resolver
+}
+
+// NewCache returns a Cache of next holding at most size responses, a
+// default size is used if size is not positive.
+func NewCache(next Resolver, size int) *Cache {
+	if size <= 0 {
+		size = defaultCacheSize
+	}
+	return &Cache{
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[cacheKey]*list.Element),
+		next:    next,
+	}
+}
+
+func (c *Cache) Exchange(ctx context.Context, query *dnsmessage.Message) (*dnsmessage.Message, error) {
+	q, name, err := question(query)
+	if err != nil {
+		return nil, err
+	}
+	key := cacheKey{name: name, qtype: q.Type, class: q.Class}
+	if resp, ok := c.get(key, query); ok {
+		return resp, nil
+	}
+	resp, err := c.next.Exchange(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	c.put(key, resp)
+	return resp, nil
+}
+
+func (c *Cache) get(key cacheKey, query *dnsmessage.Message) (*dnsmessage.Message, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	now := time.Now()
+	if !now.Before(entry.expire) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+
+	// age the records by the time spent in the cache
+	elapsed := uint32(now.Sub(entry.stored) / time.Second)
+	resp := entry.msg
+	resp.ID = query.ID
+	resp.Questions = append([]dnsmessage.Question(nil), query.Questions...)
+	resp.Answers = ageResources(entry.msg.Answers, elapsed)
+	resp.Authorities = ageResources(entry.msg.Authorities, elapsed)
+	resp.Additionals = ageResources(entry.msg.Additionals, elapsed)
+	return &resp, true
+}
+
+func (c *Cache) put(key cacheKey, resp *dnsmessage.Message) {
+	if resp.Truncated || (resp.RCode != dnsmessage.RCodeSuccess && resp.RCode != dnsmessage.RCodeNameError) {
+		return
+	}
+	ttl, ok := minTTL(resp)
+	if !ok {
+		ttl = negativeCacheTTL
+	}
+	if ttl == 0 {
+		return
+	}
+	now := time.Now()
+	entry := &cacheEntry{
+		key:    key,
+		msg:    *resp,
+		stored: now,
+		expire: now.Add(time.Duration(ttl) * time.Second),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// minTTL returns the minimum TTL of the answer records of msg, and false
+// if msg has no answer.
+func minTTL(msg *dnsmessage.Message) (uint32, bool) {
+	if len(msg.Answers) == 0 {
+		return 0, false
+	}
+	ttl := msg.Answers[0].Header.TTL
+	for _, rr := range msg.Answers[1:] {
+		ttl = min(ttl, rr.Header.TTL)
+	}
+	return ttl, true
+}
+
+func ageResources(rrs []dnsmessage.Resource, elapsed uint32) []dnsmessage.Resource {
+	if len(rrs) == 0 {
+		return nil
+	}
+	aged := make([]dnsmessage.Resource, len(rrs))
+	copy(aged, rrs)
+	for i := range aged {
+		if aged[i].Header.TTL > elapsed {
+			aged[i].Header.TTL -= elapsed
+		} else {
+			aged[i].Header.TTL = 0
+		}
+	}
+	return aged
+}
@@ -0,0 +1,165 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+
+	"github.com/josexy/netstackgo/fakeip"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func newQuery(name string, qtype dnsmessage.Type) *dnsmessage.Message {
+	return &dnsmessage.Message{
+		Header: dnsmessage.Header{ID: 0x1234, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  dnsmessage.MustNewName(name),
+			Type:  qtype,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+}
+
+func TestHosts(t *testing.T) {
+	hosts := NewHosts(map[string][]netip.Addr{
+		"Example.com": {netip.MustParseAddr("1.2.3.4"), netip.MustParseAddr("::1")},
+	}, nil)
+
+	resp, err := hosts.Exchange(context.Background(), newQuery("example.com.", dnsmessage.TypeA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Answers) != 1 || resp.Answers[0].Body.(*dnsmessage.AResource).A != [4]byte{1, 2, 3, 4} {
+		t.Fatalf("unexpected answers %v", resp.Answers)
+	}
+
+	resp, err = hosts.Exchange(context.Background(), newQuery("unknown.com.", dnsmessage.TypeA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.RCode != dnsmessage.RCodeNameError {
+		t.Fatalf("unexpected rcode %s", resp.RCode)
+	}
+}
+
+func TestFakeIP(t *testing.T) {
+	pool, err := fakeip.New(netip.MustParsePrefix("198.18.0.0/16"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := NewFakeIP(pool, nil)
+
+	resp, err := resolver.Exchange(context.Background(), newQuery("example.com.", dnsmessage.TypeA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := netip.AddrFrom4(resp.Answers[0].Body.(*dnsmessage.AResource).A)
+	if host, _ := pool.LookupBack(ip); host != "example.com" {
+		t.Fatalf("%s resolves to %q", ip, host)
+	}
+
+	resp, err = resolver.Exchange(context.Background(), newQuery("example.com.", dnsmessage.TypeAAAA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.RCode != dnsmessage.RCodeSuccess || len(resp.Answers) != 0 {
+		t.Fatalf("unexpected AAAA response %v", resp)
+	}
+}
+
+func TestCache(t *testing.T) {
+	var calls atomic.Int32
+	hosts := NewHosts(map[string][]netip.Addr{"example.com": {netip.MustParseAddr("1.2.3.4")}}, nil)
+	cache := NewCache(ResolverFunc(func(ctx context.Context, query *dnsmessage.Message) (*dnsmessage.Message, error) {
+		calls.Add(1)
+		return hosts.Exchange(ctx, query)
+	}), 0)
+
+	for i := 0; i < 3; i++ {
+		query := newQuery("example.com.", dnsmessage.TypeA)
+		query.ID = uint16(i)
+		resp, err := cache.Exchange(context.Background(), query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.ID != query.ID || len(resp.Answers) != 1 {
+			t.Fatalf("unexpected response %v", resp)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expected 1 upstream exchange, got %d", calls.Load())
+	}
+}
+
+func TestUpstream(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	hosts := NewHosts(map[string][]netip.Addr{"example.com": {netip.MustParseAddr("1.2.3.4")}}, nil)
+	go ServePacket(context.Background(), conn, hosts)
+
+	upstream := NewUpstream(netip.MustParseAddrPort(conn.LocalAddr().String()), "")
+	resp, err := upstream.Exchange(context.Background(), newQuery("example.com.", dnsmessage.TypeA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Answers) != 1 {
+		t.Fatalf("unexpected answers %v", resp.Answers)
+	}
+}
+
+func TestSnooper(t *testing.T) {
+	hosts := NewHosts(map[string][]netip.Addr{"API.example.com": {netip.MustParseAddr("1.2.3.4")}}, nil)
+	query, _ := newQuery("api.example.com.", dnsmessage.TypeA).Pack()
+	resp, err := Handle(context.Background(), hosts, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snooper := NewSnooper(0)
+	snooper.Observe(query) // queries are ignored
+	if _, ok := snooper.Lookup(netip.MustParseAddr("1.2.3.4")); ok {
+		t.Fatal("query was recorded")
+	}
+	snooper.Observe(resp)
+	if name, _ := snooper.Lookup(netip.MustParseAddr("::ffff:1.2.3.4")); name != "api.example.com" {
+		t.Fatalf("unexpected name %q", name)
+	}
+}
+
+func TestSnooperEvict(t *testing.T) {
+	answer := func(name string, addrs ...netip.Addr) *dnsmessage.Message {
+		msg := newQuery(name, dnsmessage.TypeA)
+		msg.Response = true
+		for _, addr := range addrs {
+			msg.Answers = append(msg.Answers, dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: msg.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 300},
+				Body:   &dnsmessage.AResource{A: addr.As4()},
+			})
+		}
+		return msg
+	}
+	a := netip.MustParseAddr("1.0.0.1")
+	b := netip.MustParseAddr("1.0.0.2")
+	c := netip.MustParseAddr("1.0.0.3")
+
+	snooper := NewSnooper(2)
+	snooper.ObserveMessage(answer("a.com.", a, b))
+	// answering a again makes b the least recently answered address
+	snooper.ObserveMessage(answer("x.com.", a))
+	snooper.ObserveMessage(answer("c.com.", c))
+
+	if _, ok := snooper.Lookup(b); ok {
+		t.Fatal("the least recently answered address was kept")
+	}
+	if name, _ := snooper.Lookup(a); name != "x.com" {
+		t.Fatalf("unexpected name %q of the refreshed address", name)
+	}
+	if name, _ := snooper.Lookup(c); name != "c.com" {
+		t.Fatalf("unexpected name %q", name)
+	}
+}
@@ -0,0 +1,44 @@
+package dns
+
+import (
+	"context"
+	"net/netip"
+
+	"github.com/josexy/netstackgo/fakeip"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// FakeIP answers the queries of the address family of the pool with fake
+// ip addresses. The queries of the other address family are answered with
+// no records, so that clients always connect to a fake ip address. Other
+// queries are passed to the next resolver.
+type FakeIP struct {
+	pool *fakeip.Pool
+	next Resolver
+}
+
+func NewFakeIP(pool *fakeip.Pool, next Resolver) *FakeIP {
+	return &FakeIP{pool: pool, next: next}
+}
+
+func (f *FakeIP) Exchange(ctx context.Context, query *dnsmessage.Message) (*dnsmessage.Message, error) {
+	q, name, err := question(query)
+	if err != nil {
+		return nil, err
+	}
+	is4 := f.pool.Prefix().Addr().Is4()
+	switch {
+	case q.Type == dnsmessage.TypeA && is4, q.Type == dnsmessage.TypeAAAA && !is4:
+		ip, err := f.pool.Lookup(name, fakeip.DefaultTTL)
+		if err != nil {
+			return nil, err
+		}
+		return newAddrReply(query, []netip.Addr{ip}, uint32(fakeip.DefaultTTL.Seconds())), nil
+	case q.Type == dnsmessage.TypeA, q.Type == dnsmessage.TypeAAAA:
+		return NewReply(query, dnsmessage.RCodeSuccess), nil
+	}
+	if f.next != nil {
+		return f.next.Exchange(ctx, query)
+	}
+	return NewReply(query, dnsmessage.RCodeSuccess), nil
+}
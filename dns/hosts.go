@@ -0,0 +1,77 @@
+package dns
+
+import (
+	"context"
+	"net/netip"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// defaultHostsTTL is the TTL of the answers from static hosts.
+const defaultHostsTTL = 600
+
+// Hosts answers A and AAAA queries from a static table. Queries of the
+// other names and types are passed to the next resolver, or answered with
+// NXDOMAIN if there is none.
+type Hosts struct {
+	records map[string][]netip.Addr
+	next    Resolver
+}
+
+func NewHosts(records map[string][]netip.Addr, next Resolver) *Hosts {
+	hosts := &Hosts{
+		records: make(map[string][]netip.Addr, len(records)),
+		next:    next,
+	}
+	for name, addrs := range records {
+		name = normalizeName(name)
+		hosts.records[name] = append(hosts.records[name], addrs...)
+	}
+	return hosts
+}
+
+func (h *Hosts) Exchange(ctx context.Context, query *dnsmessage.Message) (*dnsmessage.Message, error) {
+	q, name, err := question(query)
+	if err != nil {
+		return nil, err
+	}
+	addrs, ok := h.records[name]
+	if ok && (q.Type == dnsmessage.TypeA || q.Type == dnsmessage.TypeAAAA) {
+		return newAddrReply(query, addrs, defaultHostsTTL), nil
+	}
+	if h.next != nil {
+		return h.next.Exchange(ctx, query)
+	}
+	return NewReply(query, dnsmessage.RCodeNameError), nil
+}
+
+// newAddrReply returns the response of the first question of query with
+// the addresses matching the question type.
+func newAddrReply(query *dnsmessage.Message, addrs []netip.Addr, ttl uint32) *dnsmessage.Message {
+	q := query.Questions[0]
+	reply := NewReply(query, dnsmessage.RCodeSuccess)
+	reply.Authoritative = true
+	for _, addr := range addrs {
+		hdr := dnsmessage.ResourceHeader{
+			Name:  q.Name,
+			Class: dnsmessage.ClassINET,
+			TTL:   ttl,
+		}
+		addr = addr.Unmap()
+		switch {
+		case q.Type == dnsmessage.TypeA && addr.Is4():
+			hdr.Type = dnsmessage.TypeA
+			reply.Answers = append(reply.Answers, dnsmessage.Resource{
+				Header: hdr,
+				Body:   &dnsmessage.AResource{A: addr.As4()},
+			})
+		case q.Type == dnsmessage.TypeAAAA && addr.Is6():
+			hdr.Type = dnsmessage.TypeAAAA
+			reply.Answers = append(reply.Answers, dnsmessage.Resource{
+				Header: hdr,
+				Body:   &dnsmessage.AAAAResource{AAAA: addr.As16()},
+			})
+		}
+	}
+	return reply
+}
@@ -0,0 +1,147 @@
+// Package dns answers the DNS queries hijacked from the netstack.
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// maxMessageSize is the maximum size of a DNS message over TCP.
+	maxMessageSize = 65535
+
+	// defaultIdleTimeout is the time a hijacked UDP session stays open
+	// without receiving any query.
+	defaultIdleTimeout = 30 * time.Second
+)
+
+var errNoQuestion = errors.New("dns message has no question")
+
+// Resolver answers DNS queries.
+type Resolver interface {
+	// Exchange returns the response of query. The response ID is not
+	// required to match the query ID.
+	Exchange(ctx context.Context, query *dnsmessage.Message) (*dnsmessage.Message, error)
+}
+
+// ResolverFunc is an adapter to allow the use of ordinary functions as Resolver.
+type ResolverFunc func(ctx context.Context, query *dnsmessage.Message) (*dnsmessage.Message, error)
+
+func (f ResolverFunc) Exchange(ctx context.Context, query *dnsmessage.Message) (*dnsmessage.Message, error) {
+	return f(ctx, query)
+}
+
+// Handle answers the raw query with r. A SERVFAIL response is returned if
+// r fails, so that clients give up quickly instead of waiting for a timeout.
+func Handle(ctx context.Context, r Resolver, query []byte) ([]byte, error) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil {
+		return nil, err
+	}
+	if msg.Response {
+		return nil, errors.New("dns message is not a query")
+	}
+	resp, err := r.Exchange(ctx, &msg)
+	if err != nil || resp == nil {
+		resp = NewReply(&msg, dnsmessage.RCodeServerFailure)
+	}
+	resp.ID = msg.ID
+	return resp.Pack()
+}
+
+// ServePacket answers the queries read from conn until conn is closed or no
+// query arrives within the idle timeout.
+func ServePacket(ctx context.Context, conn net.PacketConn, r Resolver) error {
+	buf := make([]byte, maxMessageSize)
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(defaultIdleTimeout)); err != nil {
+			return err
+		}
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) || isTimeout(err) {
+				return nil
+			}
+			return err
+		}
+		resp, err := Handle(ctx, r, buf[:n])
+		if err != nil {
+			continue /* malformed query, drop it */
+		}
+		if _, err = conn.WriteTo(resp, addr); err != nil {
+			return err
+		}
+	}
+}
+
+// ServeConn answers the length-prefixed queries read from a stream conn
+// until conn is closed by peer.
+func ServeConn(ctx context.Context, conn net.Conn, r Resolver) error {
+	var length [2]byte
+	buf := make([]byte, maxMessageSize)
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(defaultIdleTimeout)); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			if errors.Is(err, io.EOF) || isTimeout(err) {
+				return nil
+			}
+			return err
+		}
+		query := buf[:binary.BigEndian.Uint16(length[:])]
+		if _, err := io.ReadFull(conn, query); err != nil {
+			return err
+		}
+		resp, err := Handle(ctx, r, query)
+		if err != nil {
+			return err
+		}
+		if _, err = conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(resp)))); err != nil {
+			return err
+		}
+		if _, err = conn.Write(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// NewReply returns an empty response of query with the given rcode.
+func NewReply(query *dnsmessage.Message, rcode dnsmessage.RCode) *dnsmessage.Message {
+	return &dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 query.ID,
+			Response:           true,
+			OpCode:             query.OpCode,
+			RecursionDesired:   query.RecursionDesired,
+			RecursionAvailable: true,
+			RCode:              rcode,
+		},
+		Questions: append([]dnsmessage.Question(nil), query.Questions...),
+	}
+}
+
+// question returns the first question of msg and its normalized name.
+func question(msg *dnsmessage.Message) (dnsmessage.Question, string, error) {
+	if len(msg.Questions) == 0 {
+		return dnsmessage.Question{}, "", errNoQuestion
+	}
+	q := msg.Questions[0]
+	return q, normalizeName(q.Name.String()), nil
+}
+
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,113 @@
+package dns
+
+import (
+	"container/list"
+	"net/netip"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// defaultSnoopSize is the maximum number of addresses in the Snooper.
+	defaultSnoopSize = 16384
+
+	// minSnoopTTL keeps the short-lived records for a while, because
+	// clients usually connect some time after the answer expired.
+	minSnoopTTL = 60 * time.Second
+)
+
+type snoopEntry struct {
+	addr   netip.Addr
+	name   string
+	expire time.Time
+}
+
+// Snooper records the addresses of A and AAAA answers of the DNS responses
+// flowing through the netstack, so that later connections to those
+// addresses can be labeled with the queried name.
+type Snooper struct {
+	mu      sync.RWMutex
+	size    int
+	order   *list.List // front is the least recently answered address
+	entries map[netip.Addr]*list.Element
+}
+
+// NewSnooper returns a Snooper holding at most size addresses, a default
+// size is used if size is not positive.
+func NewSnooper(size int) *Snooper {
+	if size <= 0 {
+		size = defaultSnoopSize
+	}
+	return &Snooper{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[netip.Addr]*list.Element),
+	}
+}
+
+// Observe parses the raw DNS response and records its answers.
+func (s *Snooper) Observe(resp []byte) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(resp); err != nil || !msg.Response || len(msg.Questions) == 0 {
+		return
+	}
+	s.ObserveMessage(&msg)
+}
+
+// ObserveMessage records the A and AAAA answers of msg as the addresses
+// of the queried name.
+func (s *Snooper) ObserveMessage(msg *dnsmessage.Message) {
+	if len(msg.Questions) == 0 {
+		return
+	}
+	name := normalizeName(msg.Questions[0].Name.String())
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rr := range msg.Answers {
+		var addr netip.Addr
+		switch body := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			addr = netip.AddrFrom4(body.A)
+		case *dnsmessage.AAAAResource:
+			addr = netip.AddrFrom16(body.AAAA)
+		default:
+			continue
+		}
+		ttl := max(time.Duration(rr.Header.TTL)*time.Second, minSnoopTTL)
+		s.put(snoopEntry{addr: addr, name: name, expire: now.Add(ttl)})
+	}
+}
+
+// Lookup returns the name which ip was answered for.
+func (s *Snooper) Lookup(ip netip.Addr) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	elem, ok := s.entries[ip.Unmap()]
+	if !ok {
+		return "", false
+	}
+	entry := elem.Value.(snoopEntry)
+	if time.Now().After(entry.expire) {
+		return "", false
+	}
+	return entry.name, true
+}
+
+// put records entry as the most recently answered address, and drops the
+// least recently answered one if the Snooper is full.
+func (s *Snooper) put(entry snoopEntry) {
+	if elem, ok := s.entries[entry.addr]; ok {
+		elem.Value = entry
+		s.order.MoveToBack(elem)
+		return
+	}
+	if s.order.Len() >= s.size {
+		oldest := s.order.Remove(s.order.Front()).(snoopEntry)
+		delete(s.entries, oldest.addr)
+	}
+	s.entries[entry.addr] = s.order.PushBack(entry)
+}
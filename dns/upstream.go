@@ -0,0 +1,106 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/josexy/netstackgo/bind"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// defaultUpstreamTimeout is the timeout of a single upstream exchange.
+const defaultUpstreamTimeout = 5 * time.Second
+
+// Upstream forwards queries to a plain DNS server over UDP, and retries
+// over TCP if the response is truncated.
+type Upstream struct {
+	server    netip.AddrPort
+	ifaceName string
+}
+
+// NewUpstream returns an Upstream of server. If ifaceName is not empty,
+// the queries are sent through the interface to avoid routing loops.
+func NewUpstream(server netip.AddrPort, ifaceName string) *Upstream {
+	return &Upstream{server: server, ifaceName: ifaceName}
+}
+
+func (u *Upstream) Exchange(ctx context.Context, query *dnsmessage.Message) (*dnsmessage.Message, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultUpstreamTimeout)
+		defer cancel()
+	}
+	req, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := u.exchange(ctx, "udp", req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Truncated {
+		return u.exchange(ctx, "tcp", req)
+	}
+	return resp, nil
+}
+
+func (u *Upstream) exchange(ctx context.Context, network string, req []byte) (*dnsmessage.Message, error) {
+	if u.server.Addr().Is4() {
+		network += "4"
+	} else {
+		network += "6"
+	}
+	var dialer net.Dialer
+	if u.ifaceName != "" {
+		if err := bind.BindToDeviceForConn(u.ifaceName, &dialer, network, u.server.Addr()); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := dialer.DialContext(ctx, network, u.server.String())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	buf := make([]byte, maxMessageSize)
+	var n int
+	if _, ok := conn.(*net.TCPConn); ok {
+		if _, err = conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(req)))); err != nil {
+			return nil, err
+		}
+		if _, err = conn.Write(req); err != nil {
+			return nil, err
+		}
+		if _, err = io.ReadFull(conn, buf[:2]); err != nil {
+			return nil, err
+		}
+		n = int(binary.BigEndian.Uint16(buf[:2]))
+		if _, err = io.ReadFull(conn, buf[:n]); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err = conn.Write(req); err != nil {
+			return nil, err
+		}
+		if n, err = conn.Read(buf); err != nil {
+			return nil, err
+		}
+	}
+
+	var resp dnsmessage.Message
+	if err = resp.Unpack(buf[:n]); err != nil {
+		return nil, err
+	}
+	if resp.ID != binary.BigEndian.Uint16(req) {
+		return nil, errors.New("dns response id mismatch")
+	}
+	return &resp, nil
+}
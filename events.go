@@ -0,0 +1,199 @@
+package netstackgo
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/josexy/netstackgo/tun"
+	"github.com/josexy/netstackgo/tun/core/adapter"
+)
+
+// CloseReason is why a connection was closed.
+type CloseReason string
+
+const (
+	// ReasonClosed means the connection was closed by the handler.
+	ReasonClosed CloseReason = "closed"
+	// ReasonEOF means the TUN client closed the connection.
+	ReasonEOF CloseReason = "eof"
+	// ReasonTimeout means a read or write deadline was exceeded.
+	ReasonTimeout CloseReason = "timeout"
+	// ReasonError means a read or write failed, e.g. reset by the client.
+	ReasonError CloseReason = "error"
+)
+
+// Event is one of ConnOpened, ConnClosed, HandlerError, DeviceDown,
+// RoutesApplied and RoutesRemoved.
+type Event interface {
+	// Time returns when the event happened.
+	Time() time.Time
+}
+
+type eventTime time.Time
+
+func (t eventTime) Time() time.Time { return time.Time(t) }
+
+// ConnOpened is published before a connection is passed to the handler.
+type ConnOpened struct {
+	eventTime
+	ID      uint64
+	Network string
+	Tuple   ConnTuple
+}
+
+// ConnClosed is published after the handler of a connection returned.
+type ConnClosed struct {
+	eventTime
+	ID        uint64
+	Network   string
+	Tuple     ConnTuple
+	BytesUp   uint64
+	BytesDown uint64
+	Duration  time.Duration
+	Reason    CloseReason
+	// Err is the first read or write error for ReasonTimeout and
+	// ReasonError.
+	Err error
+}
+
+// HandlerError is published for the errors reported to the ErrorHandler.
+type HandlerError struct {
+	eventTime
+	Kind  adapter.ErrorKind
+	Tuple ConnTuple
+	Err   error
+}
+
+// DeviceDown is published when the TUN device stops reading packets, Err is
+// nil if the netstack is closed.
+type DeviceDown struct {
+	eventTime
+	Name string
+	Err  error
+}
+
+// RoutesApplied is published after the routes to the TUN device are added.
+type RoutesApplied struct {
+	eventTime
+	Name   string
+	Routes []tun.IPRoute
+}
+
+// RoutesRemoved is published after the routes to the TUN device are
+// removed on Close.
+type RoutesRemoved struct {
+	eventTime
+	Name   string
+	Routes []tun.IPRoute
+	Err    error
+}
+
+// Subscription receives the events published after Subscribe.
+type Subscription struct {
+	bus     *eventBus
+	ch      chan Event
+	dropped atomic.Uint64
+}
+
+// C returns the channel of the events, it's closed by Unsubscribe.
+func (s *Subscription) C() <-chan Event { return s.ch }
+
+// Dropped returns the number of events dropped because the buffer was full.
+func (s *Subscription) Dropped() uint64 { return s.dropped.Load() }
+
+// Unsubscribe stops the delivery and closes the channel.
+func (s *Subscription) Unsubscribe() { s.bus.unsubscribe(s) }
+
+// eventBus delivers the events to the subscriptions without blocking.
+type eventBus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+	// dropped is the number of events dropped by all subscriptions.
+	dropped atomic.Uint64
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: make(map[*Subscription]struct{})}
+}
+
+func (b *eventBus) subscribe(buffer int) *Subscription {
+	s := &Subscription{bus: b, ch: make(chan Event, buffer)}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[s] = struct{}{}
+	return s
+}
+
+func (b *eventBus) unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
+
+// active reports whether there is any subscription, so that the events
+// needn't be built otherwise.
+func (b *eventBus) active() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subs) > 0
+}
+
+func (b *eventBus) publish(ev Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subs {
+		select {
+		case s.ch <- ev:
+		default:
+			s.dropped.Add(1)
+			b.dropped.Add(1)
+		}
+	}
+}
+
+// Subscribe returns a subscription to the connection, error, device and
+// route events, buffering at most buffer events. The events are dropped
+// instead of blocking the netstack if the buffer is full.
+func (ns *TunNetstack) Subscribe(buffer int) *Subscription {
+	return ns.handler.events.subscribe(buffer)
+}
+
+// DroppedEvents returns the number of events dropped by all subscriptions.
+func (ns *TunNetstack) DroppedEvents() uint64 {
+	return ns.handler.events.dropped.Load()
+}
+
+func (h *tunTransportHandler) publishConnOpened(tracked *trackedConn) {
+	if !h.events.active() {
+		return
+	}
+	h.events.publish(ConnOpened{
+		eventTime: eventTime(tracked.start),
+		ID:        tracked.id,
+		Network:   tracked.network,
+		Tuple:     tracked.tuple,
+	})
+}
+
+func (h *tunTransportHandler) publishConnClosed(tracked *trackedConn) {
+	if !h.events.active() {
+		return
+	}
+	now := time.Now()
+	reason, err := tracked.closeReason()
+	h.events.publish(ConnClosed{
+		eventTime: eventTime(now),
+		ID:        tracked.id,
+		Network:   tracked.network,
+		Tuple:     tracked.tuple,
+		BytesUp:   tracked.up.Load(),
+		BytesDown: tracked.down.Load(),
+		Duration:  now.Sub(tracked.start),
+		Reason:    reason,
+		Err:       err,
+	})
+}
@@ -0,0 +1,171 @@
+package netstackgo
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/josexy/netstackgo/tun"
+	"github.com/josexy/netstackgo/tun/core/device/generic"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+)
+
+func TestEventBusDropsWhenFull(t *testing.T) {
+	ns := New(tun.TunConfig{})
+	sub := ns.Subscribe(1)
+
+	tracked, _ := ns.handler.conns.addTCP(ConnTuple{}, nil)
+	tracked.up.Add(3)
+	ns.handler.publishConnOpened(tracked)
+	ns.handler.publishConnClosed(tracked)
+
+	ev := <-sub.C()
+	if opened, ok := ev.(ConnOpened); !ok || opened.ID != tracked.id {
+		t.Fatalf("unexpected event %#v", ev)
+	}
+	if sub.Dropped() != 1 || ns.DroppedEvents() != 1 {
+		t.Fatalf("dropped = %d, %d, want 1", sub.Dropped(), ns.DroppedEvents())
+	}
+
+	ns.handler.publishConnClosed(tracked)
+	closed := (<-sub.C()).(ConnClosed)
+	if closed.BytesUp != 3 || closed.Reason != ReasonClosed {
+		t.Fatalf("unexpected event %#v", closed)
+	}
+
+	sub.Unsubscribe()
+	if _, ok := <-sub.C(); ok {
+		t.Fatal("channel is not closed")
+	}
+	ns.handler.publishConnClosed(tracked)
+}
+
+func TestCloseReason(t *testing.T) {
+	for _, tt := range []struct {
+		err    error
+		reason CloseReason
+	}{
+		{nil, ReasonClosed},
+		{io.EOF, ReasonEOF},
+		{os.ErrDeadlineExceeded, ReasonTimeout},
+		{io.ErrUnexpectedEOF, ReasonError},
+	} {
+		c := &trackedConn{}
+		c.recordError(tt.err)
+		if reason, _ := c.closeReason(); reason != tt.reason {
+			t.Errorf("closeReason(%v) = %s, want %s", tt.err, reason, tt.reason)
+		}
+	}
+}
+
+// fakeHost records the routes instead of configuring the host.
+type fakeHost struct {
+	addr   string
+	routes []tun.IPRoute
+}
+
+func (h *fakeHost) SetTunAddress(_, addr string, _ uint32) error {
+	h.addr = addr
+	return nil
+}
+
+func (h *fakeHost) AddTunRoutes(_ string, routes []tun.IPRoute) error {
+	h.routes = append(h.routes, routes...)
+	return nil
+}
+
+func (h *fakeHost) DelTunRoutes(_ string, routes []tun.IPRoute) error {
+	h.routes = h.routes[:len(h.routes)-len(routes)]
+	return nil
+}
+
+// nextEvent returns the next event of sub, skipping the other event types.
+func nextEvent[T Event](t *testing.T, sub *Subscription) T {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-sub.C():
+			if ev, ok := ev.(T); ok {
+				return ev
+			}
+		case <-timeout:
+			var zero T
+			t.Fatalf("no %T was published", zero)
+			return zero
+		}
+	}
+}
+
+func TestDeviceAndRouteEvents(t *testing.T) {
+	local, remote := net.Pipe()
+	dev, err := generic.NewFramed("pipe", local, 1500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := &fakeHost{}
+	ns := New(tun.TunConfig{Name: "tun9", Addr: "198.18.0.1/16", MTU: 1500}, WithDevice(dev))
+	ns.host = host
+	sub := ns.Subscribe(32)
+	defer sub.Unsubscribe()
+	if err = ns.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	applied := nextEvent[RoutesApplied](t, sub)
+	if applied.Name != "tun9" || len(applied.Routes) != len(defaultCIDRRoutes) || len(host.routes) != len(applied.Routes) {
+		t.Fatalf("unexpected %+v, host routes %v", applied, host.routes)
+	}
+	if host.addr != "198.18.0.1/16" {
+		t.Fatalf("unexpected tun address %q", host.addr)
+	}
+
+	// the peer of the device goes away
+	remote.Close()
+	if down := nextEvent[DeviceDown](t, sub); down.Name != "tun9" || down.Err == nil {
+		t.Fatalf("unexpected %+v after a read error", down)
+	}
+
+	if err = ns.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if removed := nextEvent[RoutesRemoved](t, sub); len(removed.Routes) != len(applied.Routes) || removed.Err != nil {
+		t.Fatalf("unexpected %+v", removed)
+	}
+	if down := nextEvent[DeviceDown](t, sub); down.Err != nil {
+		t.Fatalf("unexpected %+v on Close", down)
+	}
+	if len(host.routes) != 0 {
+		t.Fatalf("routes %v left after Close", host.routes)
+	}
+}
+
+func TestConnEvents(t *testing.T) {
+	ns, client := startTestNetstack(t)
+	ns.RegisterConnHandler(nopHandler{})
+	sub := ns.Subscribe(32)
+	defer sub.Unsubscribe()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := gonet.DialContextTCP(ctx, client, tcpip.FullAddress{
+		NIC:  1,
+		Addr: tcpip.AddrFrom4([4]byte{1, 1, 1, 1}),
+		Port: 80,
+	}, ipv4.ProtocolNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	opened := nextEvent[ConnOpened](t, sub)
+	closed := nextEvent[ConnClosed](t, sub)
+	if opened.ID != closed.ID || opened.Tuple.DstAddr.String() != "1.1.1.1:80" || opened.Tuple.SrcAddr.Addr() != testClientAddr {
+		t.Fatalf("unexpected events %+v, %+v", opened, closed)
+	}
+}
@@ -3,18 +3,17 @@ package main
 import (
 	"context"
 	"flag"
-	"io"
 	"log"
 	"net"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
 	"time"
 
 	"github.com/josexy/netstackgo"
 	"github.com/josexy/netstackgo/bind"
 	"github.com/josexy/netstackgo/iface"
+	"github.com/josexy/netstackgo/relay"
 	"github.com/josexy/netstackgo/tun"
 )
 
@@ -24,19 +23,6 @@ var (
 	remoteAddr string = ""
 )
 
-func tunnel(dst, src io.ReadWriteCloser) {
-	var wg sync.WaitGroup
-	wg.Add(2)
-	fn := func(dest, src io.ReadWriteCloser) {
-		defer wg.Done()
-		_, _ = io.Copy(dest, src)
-		_ = dest.Close()
-	}
-	go fn(dst, src)
-	go fn(src, dst)
-	wg.Wait()
-}
-
 type handler struct{}
 
 func (h *handler) HandleTCPConn(info netstackgo.ConnTuple, conn net.Conn) {
@@ -57,8 +43,9 @@ func (h *handler) HandleTCPConn(info netstackgo.ConnTuple, conn net.Conn) {
 		log.Println(err)
 		return
 	}
-	defer target.Close()
-	tunnel(target, conn)
+	res := relay.Relay(conn, target, relay.Options{IdleTimeout: time.Minute * 5})
+	log.Printf("tcp, src: %s, dst: %s, up: %d, down: %d, reason: %s",
+		info.Src(), info.Dst(), res.Up, res.Down, res.Reason)
 }
 
 func (h *handler) HandleUDPConn(info netstackgo.ConnTuple, conn net.PacketConn) {
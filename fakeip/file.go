@@ -0,0 +1,83 @@
+package fakeip
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+const snapshotVersion = 1
+
+type snapshot struct {
+	Version int     `json:"version"`
+	Entries []Entry `json:"entries"`
+}
+
+// FileStore is an on-disk Store. The table is kept in memory and
+// snapshotted to a JSON file by Save, and reloaded by Load.
+type FileStore struct {
+	*MemoryStore
+	path string
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{
+		MemoryStore: NewMemoryStore(),
+		path:        path,
+	}
+}
+
+// Load reloads the table from the snapshot file, a missing file is not an error.
+func (s *FileStore) Load() error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("read fakeip snapshot: %w", err)
+	}
+	var snap snapshot
+	if err = json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("decode fakeip snapshot: %w", err)
+	}
+	if snap.Version != snapshotVersion {
+		return fmt.Errorf("unsupported fakeip snapshot version %d", snap.Version)
+	}
+	// restore the LRU order in case the file was edited by hand
+	slices.SortStableFunc(snap.Entries, func(a, b Entry) int {
+		return a.LastUsed.Compare(b.LastUsed)
+	})
+	s.Restore(snap.Entries)
+	return nil
+}
+
+// Save writes the snapshot to a temporary file and renames it, so that
+// a crash during Save never leaves a truncated snapshot behind.
+func (s *FileStore) Save() error {
+	data, err := json.Marshal(snapshot{
+		Version: snapshotVersion,
+		Entries: s.Entries(),
+	})
+	if err != nil {
+		return fmt.Errorf("encode fakeip snapshot: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("write fakeip snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write fakeip snapshot: %w", err)
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
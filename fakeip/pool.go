@@ -0,0 +1,191 @@
+// Package fakeip maps domain names to addresses allocated from a reserved
+// prefix, so that connections to a fake ip address can be traced back to
+// the domain name the client resolved.
+package fakeip
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTTL is the TTL of the fake ip address handed out to clients.
+const DefaultTTL = 60 * time.Second
+
+// ErrExhausted is returned by Lookup if every address of the pool is
+// allocated and none has expired. Reclaiming an unexpired address would
+// hand an address still cached by clients to another domain.
+var ErrExhausted = errors.New("fakeip pool is exhausted")
+
+// Pool allocates fake ip addresses from a prefix. When the pool is exhausted,
+// the least recently used expired address is reclaimed.
+type Pool struct {
+	mu     sync.Mutex
+	prefix netip.Prefix
+	first  netip.Addr
+	last   netip.Addr
+	cursor netip.Addr
+	store  Store
+}
+
+// New returns a Pool for prefix. The network address and the first host
+// address (usually the gateway) of prefix are never allocated.
+// If store is nil, a MemoryStore is used.
+func New(prefix netip.Prefix, store Store) (*Pool, error) {
+	prefix = prefix.Masked()
+	first := prefix.Addr().Next().Next()
+	last := lastAddr(prefix)
+	if prefix.Addr().Is4() {
+		last = last.Prev() // broadcast address
+	}
+	if !first.IsValid() || !last.IsValid() || last.Less(first) {
+		return nil, errors.New("fakeip prefix is too small")
+	}
+	if store == nil {
+		store = NewMemoryStore()
+	}
+	return &Pool{
+		prefix: prefix,
+		first:  first,
+		last:   last,
+		cursor: first,
+		store:  store,
+	}, nil
+}
+
+// Lookup returns the fake ip address of host, allocating one if needed.
+// The entry is kept alive for at least ttl.
+func (p *Pool) Lookup(host string, ttl time.Duration) (netip.Addr, error) {
+	host = normalizeHost(host)
+	now := time.Now()
+	expire := now.Add(ttl)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if entry, ok := p.store.GetByHost(host); ok {
+		entry.LastUsed = now
+		if entry.Expire.Before(expire) {
+			entry.Expire = expire
+		}
+		p.store.Put(entry)
+		return entry.Addr, nil
+	}
+
+	ip, err := p.allocate(now)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	entry := Entry{
+		Host:     host,
+		Addr:     ip,
+		Expire:   expire,
+		LastUsed: now,
+	}
+	p.store.Put(entry)
+	return entry.Addr, nil
+}
+
+// LookupBack returns the host which ip was allocated for.
+func (p *Pool) LookupBack(ip netip.Addr) (string, bool) {
+	if !p.Contains(ip) {
+		return "", false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry, ok := p.store.GetByIP(ip.Unmap())
+	if !ok {
+		return "", false
+	}
+	entry.LastUsed = time.Now()
+	p.store.Put(entry)
+	return entry.Host, true
+}
+
+// TTL returns the remaining lifetime of the fake ip address of host.
+func (p *Pool) TTL(host string) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry, ok := p.store.GetByHost(normalizeHost(host))
+	if !ok {
+		return 0
+	}
+	return max(time.Until(entry.Expire), 0)
+}
+
+// Contains reports whether ip belongs to the pool.
+func (p *Pool) Contains(ip netip.Addr) bool {
+	return p.prefix.Contains(ip.Unmap())
+}
+
+// Prefix returns the prefix of the pool.
+func (p *Pool) Prefix() netip.Prefix {
+	return p.prefix
+}
+
+// Load restores the table from the store, dropping entries which don't
+// belong to the pool any more.
+func (p *Pool) Load() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.store.Load(); err != nil {
+		return err
+	}
+	for _, entry := range p.store.Entries() {
+		if !p.inRange(entry.Addr) {
+			p.store.DelByIP(entry.Addr)
+		}
+	}
+	return nil
+}
+
+// Save snapshots the table to the store.
+func (p *Pool) Save() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.store.Save()
+}
+
+// allocate returns the next unused address, or reclaims the least
+// recently used one expired before now if the pool is exhausted.
+func (p *Pool) allocate(now time.Time) (netip.Addr, error) {
+	start := p.cursor
+	for {
+		ip := p.cursor
+		if p.cursor = ip.Next(); !p.inRange(p.cursor) {
+			p.cursor = p.first
+		}
+		if _, used := p.store.GetByIP(ip); !used {
+			return ip, nil
+		}
+		if p.cursor == start {
+			break
+		}
+	}
+	for _, entry := range p.store.Entries() {
+		if !entry.Expire.After(now) {
+			p.store.DelByIP(entry.Addr)
+			return entry.Addr, nil
+		}
+	}
+	return netip.Addr{}, ErrExhausted
+}
+
+func (p *Pool) inRange(ip netip.Addr) bool {
+	return ip.IsValid() && !ip.Less(p.first) && !p.last.Less(ip)
+}
+
+func normalizeHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().AsSlice()
+	for i := prefix.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 1 << (7 - i%8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
@@ -0,0 +1,166 @@
+package fakeip
+
+import (
+	"net/netip"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// unsyncStore counts the calls without synchronization, so that the race
+// detector reports the store accesses not serialized by Pool.
+type unsyncStore struct {
+	*MemoryStore
+	calls int
+}
+
+func (s *unsyncStore) GetByHost(host string) (Entry, bool) {
+	s.calls++
+	return s.MemoryStore.GetByHost(host)
+}
+
+func (s *unsyncStore) Put(entry Entry) {
+	s.calls++
+	s.MemoryStore.Put(entry)
+}
+
+func TestPoolLookup(t *testing.T) {
+	pool, err := New(netip.MustParsePrefix("198.18.0.0/29"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip := mustLookup(t, pool, "Example.com.", DefaultTTL)
+	if ip != netip.MustParseAddr("198.18.0.2") {
+		t.Fatalf("unexpected first address %s", ip)
+	}
+	if again := mustLookup(t, pool, "example.com", DefaultTTL); again != ip {
+		t.Fatalf("lookup is not stable: %s != %s", again, ip)
+	}
+	if host, ok := pool.LookupBack(ip); !ok || host != "example.com" {
+		t.Fatalf("lookup back %s: %q %v", ip, host, ok)
+	}
+	if _, ok := pool.LookupBack(netip.MustParseAddr("10.0.0.1")); ok {
+		t.Fatal("address outside of the pool resolved")
+	}
+}
+
+func TestPoolEvictLRU(t *testing.T) {
+	// 198.18.0.2 - 198.18.0.6 are usable
+	pool, err := New(netip.MustParsePrefix("198.18.0.0/29"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := []string{"a.com", "b.com", "c.com", "d.com", "e.com"}
+	ips := make(map[string]netip.Addr)
+	for _, host := range hosts {
+		ips[host] = mustLookup(t, pool, host, 0) // expired at once
+	}
+	// refresh a.com so that b.com becomes the least recently used one
+	mustLookup(t, pool, "a.com", DefaultTTL)
+
+	ip := mustLookup(t, pool, "f.com", DefaultTTL)
+	if ip != ips["b.com"] {
+		t.Fatalf("expected %s to be reclaimed, got %s", ips["b.com"], ip)
+	}
+	if _, ok := pool.LookupBack(ips["a.com"]); !ok {
+		t.Fatal("recently used entry was evicted")
+	}
+	if host, _ := pool.LookupBack(ip); host != "f.com" {
+		t.Fatalf("reclaimed address resolves to %q", host)
+	}
+}
+
+func TestPoolExhausted(t *testing.T) {
+	pool, err := New(netip.MustParsePrefix("198.18.0.0/29"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"a.com", "b.com", "c.com", "d.com"} {
+		mustLookup(t, pool, host, DefaultTTL)
+	}
+	expired := mustLookup(t, pool, "e.com", 0)
+
+	// only the expired address can be reclaimed
+	if ip := mustLookup(t, pool, "f.com", DefaultTTL); ip != expired {
+		t.Fatalf("expected the expired %s to be reclaimed, got %s", expired, ip)
+	}
+	if ip, err := pool.Lookup("g.com", DefaultTTL); err != ErrExhausted {
+		t.Fatalf("Lookup = %s, %v, want ErrExhausted", ip, err)
+	}
+	if host, _ := pool.LookupBack(netip.MustParseAddr("198.18.0.2")); host != "a.com" {
+		t.Fatalf("unexpired address was reclaimed for %q", host)
+	}
+}
+
+func TestFileStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fakeip.json")
+	prefix := netip.MustParsePrefix("198.18.0.0/16")
+
+	pool, err := New(prefix, NewFileStore(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := mustLookup(t, pool, "a.com", time.Hour)
+	b := mustLookup(t, pool, "b.com", time.Hour)
+	if err = pool.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	pool, err = New(prefix, NewFileStore(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = pool.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if host, _ := pool.LookupBack(b); host != "b.com" {
+		t.Fatalf("%s resolves to %q after reload", b, host)
+	}
+	// a restarted pool must not hand out cached addresses to other domains
+	if c := mustLookup(t, pool, "c.com", time.Hour); c == a || c == b {
+		t.Fatalf("reused address %s", c)
+	}
+	if ttl := pool.TTL("a.com"); ttl <= 0 || ttl > time.Hour {
+		t.Fatalf("unexpected ttl %s", ttl)
+	}
+}
+
+func mustLookup(t *testing.T, pool *Pool, host string, ttl time.Duration) netip.Addr {
+	t.Helper()
+	ip, err := pool.Lookup(host, ttl)
+	if err != nil {
+		t.Fatalf("lookup %s: %v", host, err)
+	}
+	return ip
+}
+
+func TestPoolTTL(t *testing.T) {
+	pool, err := New(netip.MustParsePrefix("198.18.0.0/29"), &unsyncStore{MemoryStore: NewMemoryStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl := pool.TTL("a.com"); ttl != 0 {
+		t.Fatalf("unexpected ttl %v of an unknown host", ttl)
+	}
+	mustLookup(t, pool, "a.com", time.Minute)
+	if ttl := pool.TTL("a.com"); ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("unexpected ttl %v", ttl)
+	}
+
+	// the expired entries are reclaimed while TTL reads them
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := range 100 {
+			pool.Lookup(strconv.Itoa(i)+".com", 0)
+		}
+	}()
+	for i := range 100 {
+		pool.TTL(strconv.Itoa(i) + ".com")
+	}
+	wg.Wait()
+}
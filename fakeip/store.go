@@ -0,0 +1,149 @@
+package fakeip
+
+import (
+	"container/list"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Entry is a single record of the domain <-> fake ip address table.
+type Entry struct {
+	Host string     `json:"host"`
+	Addr netip.Addr `json:"addr"`
+	// Expire is the time when the last DNS answer handed out for this
+	// entry expires in the client caches.
+	Expire time.Time `json:"expire"`
+	// LastUsed is the time of the last lookup, used for LRU eviction.
+	LastUsed time.Time `json:"last_used"`
+}
+
+// Store is the pluggable storage of the domain <-> fake ip address table.
+type Store interface {
+	// GetByHost returns the entry of host.
+	GetByHost(host string) (Entry, bool)
+
+	// GetByIP returns the entry of ip.
+	GetByIP(ip netip.Addr) (Entry, bool)
+
+	// Put inserts or replaces the entry and marks it as the most
+	// recently used one.
+	Put(entry Entry)
+
+	// DelByIP removes the entry of ip.
+	DelByIP(ip netip.Addr)
+
+	// Len returns the number of entries.
+	Len() int
+
+	// Entries returns all entries ordered from the least to the most
+	// recently used.
+	Entries() []Entry
+
+	// Load restores the table from the underlying storage.
+	Load() error
+
+	// Save snapshots the table to the underlying storage.
+	Save() error
+}
+
+// MemoryStore is an in-memory Store, the table is lost when the process exits.
+type MemoryStore struct {
+	mu     sync.Mutex
+	lru    *list.List // front is the most recently used entry
+	byHost map[string]*list.Element
+	byIP   map[netip.Addr]*list.Element
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		lru:    list.New(),
+		byHost: make(map[string]*list.Element),
+		byIP:   make(map[netip.Addr]*list.Element),
+	}
+}
+
+func (s *MemoryStore) GetByHost(host string) (Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elem, ok := s.byHost[host]; ok {
+		return elem.Value.(Entry), true
+	}
+	return Entry{}, false
+}
+
+func (s *MemoryStore) GetByIP(ip netip.Addr) (Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elem, ok := s.byIP[ip]; ok {
+		return elem.Value.(Entry), true
+	}
+	return Entry{}, false
+}
+
+func (s *MemoryStore) Put(entry Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(entry)
+}
+
+func (s *MemoryStore) put(entry Entry) {
+	// both host and ip must be unique in the table
+	if elem, ok := s.byHost[entry.Host]; ok {
+		s.remove(elem)
+	}
+	if elem, ok := s.byIP[entry.Addr]; ok {
+		s.remove(elem)
+	}
+	elem := s.lru.PushFront(entry)
+	s.byHost[entry.Host] = elem
+	s.byIP[entry.Addr] = elem
+}
+
+func (s *MemoryStore) DelByIP(ip netip.Addr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elem, ok := s.byIP[ip]; ok {
+		s.remove(elem)
+	}
+}
+
+func (s *MemoryStore) remove(elem *list.Element) {
+	entry := s.lru.Remove(elem).(Entry)
+	delete(s.byHost, entry.Host)
+	delete(s.byIP, entry.Addr)
+}
+
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lru.Len()
+}
+
+// Entries returns all entries ordered from the least to the most recently used.
+func (s *MemoryStore) Entries() []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make([]Entry, 0, s.lru.Len())
+	for elem := s.lru.Back(); elem != nil; elem = elem.Prev() {
+		entries = append(entries, elem.Value.(Entry))
+	}
+	return entries
+}
+
+// Restore replaces all entries, the entries must be ordered from the least
+// to the most recently used.
+func (s *MemoryStore) Restore(entries []Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lru.Init()
+	clear(s.byHost)
+	clear(s.byIP)
+	for _, entry := range entries {
+		s.put(entry)
+	}
+}
+
+func (s *MemoryStore) Load() error { return nil }
+
+func (s *MemoryStore) Save() error { return nil }
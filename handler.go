@@ -1,13 +1,27 @@
 package netstackgo
 
 import (
+	"context"
+	"fmt"
+	"log/slog"
 	"net"
 	"net/netip"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/josexy/netstackgo/accesslog"
+	"github.com/josexy/netstackgo/dns"
+	"github.com/josexy/netstackgo/fakeip"
+	"github.com/josexy/netstackgo/sniff"
 	"github.com/josexy/netstackgo/tun/core/adapter"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 )
 
+// ConnHandler handles the connections terminated by the netstack. The conn
+// passed to HandleTCPConn implements adapter.TCPConn, which exposes the
+// half-close, abortive close and socket options of the connection.
 type ConnHandler interface {
 	HandleTCPConn(ConnTuple, net.Conn)
 	HandleUDPConn(ConnTuple, net.PacketConn)
@@ -16,6 +30,20 @@ type ConnHandler interface {
 type ConnTuple struct {
 	SrcAddr netip.AddrPort
 	DstAddr netip.AddrPort
+	// Domain is the domain name of DstAddr if known, e.g. DstAddr
+	// is a fake ip address or was seen in a snooped DNS response.
+	Domain string
+	// Host is the TLS server name or HTTP Host sniffed from the
+	// connection payload, or the server name in QUIC Initial packets,
+	// see WithSniffing.
+	Host string
+	// ALPN is the application protocols offered in TLS ClientHello, joined
+	// by commas in the client's order, e.g. "h2,http/1.1". It's a string
+	// so that ConnTuple stays comparable, see ALPNProtocols.
+	ALPN string
+	// Protocol is the application protocol labeled from the first client
+	// bytes, it's empty if sniffing is disabled.
+	Protocol sniff.Protocol
 }
 
 func newConnTuple(id *stack.TransportEndpointID) ConnTuple {
@@ -35,12 +63,46 @@ func (t *ConnTuple) Dst() string {
 	return t.DstAddr.String()
 }
 
+// ALPNProtocols returns the application protocols offered in TLS
+// ClientHello, or nil if none is offered.
+func (t *ConnTuple) ALPNProtocols() []string {
+	if t.ALPN == "" {
+		return nil
+	}
+	return strings.Split(t.ALPN, ",")
+}
+
+// Hostname returns the sniffed Host, or Domain if nothing was sniffed.
+func (t *ConnTuple) Hostname() string {
+	if t.Host != "" {
+		return t.Host
+	}
+	return t.Domain
+}
+
+// ErrorHandler is called with the errors reported by the netstack, the
+// device and the connection handling. The tuple is zero if the error isn't
+// specific to a connection, e.g. adapter.ErrorWritePacket.
+type ErrorHandler func(kind adapter.ErrorKind, tuple ConnTuple, err error)
+
 type tunTransportHandler struct {
 	tcpQueue chan adapter.TCPConn
 	udpQueue chan adapter.UDPConn
 	closeCh  chan struct{}
 	adapter.TransportHandler
-	connHandler ConnHandler
+	mu           sync.RWMutex
+	connHandler  ConnHandler
+	tcpListener  *TCPListener
+	udpListener  *UDPListener
+	conns        *connTable
+	events       *eventBus
+	accessLog    *accesslog.Logger
+	fakeIPPool   *fakeip.Pool
+	dnsResolver  dns.Resolver
+	dnsSnooper   *dns.Snooper
+	sniffTimeout time.Duration
+	logger       *slog.Logger
+	onError      ErrorHandler
 }
 
 func newTunTransportHandler() *tunTransportHandler {
@@ -48,18 +110,28 @@ func newTunTransportHandler() *tunTransportHandler {
 		tcpQueue: make(chan adapter.TCPConn, 128),
 		udpQueue: make(chan adapter.UDPConn, 128),
 		closeCh:  make(chan struct{}, 1),
+		conns:    newConnTable(),
+		events:   newEventBus(),
 	}
 	handler.TransportHandler = handler
 	return handler
 }
 
 func (h *tunTransportHandler) registerConnHandler(handler ConnHandler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 	h.connHandler = handler
 }
 
+// targets returns the current ConnHandler and listeners.
+func (h *tunTransportHandler) targets() (ConnHandler, *TCPListener, *UDPListener) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.connHandler, h.tcpListener, h.udpListener
+}
+
 func (h *tunTransportHandler) run() {
 	go func() {
-		defer func() { recover() }()
 		for {
 			select {
 			case conn := <-h.tcpQueue:
@@ -81,19 +153,182 @@ func (h *tunTransportHandler) HandleTCP(conn adapter.TCPConn) { h.tcpQueue <- co
 
 func (h *tunTransportHandler) HandleUDP(conn adapter.UDPConn) { h.udpQueue <- conn }
 
+func (h *tunTransportHandler) reportError(kind adapter.ErrorKind, connTuple ConnTuple, err error) {
+	if h.logger != nil {
+		attrs := []any{slog.String("kind", string(kind)), slog.Any("error", err)}
+		if connTuple.SrcAddr.IsValid() {
+			attrs = append(attrs, slog.String("src", connTuple.Src()), slog.String("dst", connTuple.Dst()))
+		}
+		h.logger.Error("netstack error", attrs...)
+	}
+	if h.onError != nil {
+		h.onError(kind, connTuple, err)
+	}
+	if h.events.active() {
+		h.events.publish(HandlerError{eventTime: eventTime(time.Now()), Kind: kind, Tuple: connTuple, Err: err})
+	}
+}
+
+// reportEndpointError implements adapter.ErrorHandler for tun/core and the
+// device.
+func (h *tunTransportHandler) reportEndpointError(kind adapter.ErrorKind, id *stack.TransportEndpointID, err error) {
+	var connTuple ConnTuple
+	if id != nil {
+		connTuple = h.resolveConnTuple(id)
+	}
+	h.reportError(kind, connTuple, err)
+}
+
+func (h *tunTransportHandler) logConn(msg string, tracked *trackedConn) {
+	if h.logger == nil || !h.logger.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	h.logger.Debug(msg,
+		slog.Uint64("id", tracked.id),
+		slog.String("network", tracked.network),
+		slog.String("src", tracked.tuple.Src()),
+		slog.String("dst", tracked.tuple.Dst()),
+		slog.String("host", tracked.tuple.Hostname()),
+		slog.Uint64("up", tracked.up.Load()),
+		slog.Uint64("down", tracked.down.Load()),
+		slog.Duration("duration", time.Since(tracked.start)),
+	)
+}
+
+func (h *tunTransportHandler) logAccess(tracked *trackedConn) {
+	if h.accessLog == nil {
+		return
+	}
+	now := time.Now()
+	reason, err := tracked.closeReason()
+	route := tracked.getRoute()
+	rec := accesslog.Record{
+		Time:      now,
+		Proto:     tracked.network,
+		Protocol:  string(tracked.tuple.Protocol),
+		Src:       tracked.tuple.Src(),
+		Dst:       tracked.tuple.Dst(),
+		Domain:    tracked.tuple.Hostname(),
+		Rule:      route.rule,
+		Handler:   route.handler,
+		BytesUp:   tracked.up.Load(),
+		BytesDown: tracked.down.Load(),
+		Duration:  now.Sub(tracked.start).Seconds(),
+		Reason:    string(reason),
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if err := h.accessLog.Log(rec); err != nil {
+		h.reportError(adapter.ErrorAccessLog, tracked.tuple, err)
+	}
+}
+
+func (h *tunTransportHandler) resolveConnTuple(id *stack.TransportEndpointID) ConnTuple {
+	connTuple := newConnTuple(id)
+	if h.fakeIPPool != nil {
+		connTuple.Domain, _ = h.fakeIPPool.LookupBack(connTuple.DstAddr.Addr())
+	}
+	if connTuple.Domain == "" && h.dnsSnooper != nil {
+		connTuple.Domain, _ = h.dnsSnooper.Lookup(connTuple.DstAddr.Addr())
+	}
+	return connTuple
+}
+
+func (h *tunTransportHandler) isDNSHijacked(connTuple ConnTuple) bool {
+	return h.dnsResolver != nil && connTuple.DstAddr.Port() == 53
+}
+
+func (h *tunTransportHandler) isDNSSnooped(connTuple ConnTuple) bool {
+	return h.dnsSnooper != nil && connTuple.DstAddr.Port() == 53
+}
+
+// track logs and publishes the opening of tracked, and returns the function
+// logging, publishing and untracking its closing.
+func (h *tunTransportHandler) track(tracked *trackedConn) func() {
+	h.logConn("connection opened", tracked)
+	h.publishConnOpened(tracked)
+	return func() {
+		h.logAccess(tracked)
+		h.publishConnClosed(tracked)
+		h.logConn("connection closed", tracked)
+		h.conns.remove(tracked)
+	}
+}
+
+// recoverPanic reports the panic in handling the connection id, so that a
+// faulty ConnHandler doesn't crash the process.
+func (h *tunTransportHandler) recoverPanic(id *stack.TransportEndpointID) {
+	if r := recover(); r != nil {
+		h.reportError(adapter.ErrorHandlerPanic, h.resolveConnTuple(id), fmt.Errorf("panic: %v\n%s", r, debug.Stack()))
+	}
+}
+
 func (h *tunTransportHandler) handleTCPConn(conn adapter.TCPConn) {
 	defer conn.Close()
-	connTuple := newConnTuple(conn.ID())
-	if h.connHandler != nil {
-		h.connHandler.HandleTCPConn(connTuple, conn)
+	defer h.recoverPanic(conn.ID())
+	connTuple := h.resolveConnTuple(conn.ID())
+	if h.isDNSSnooped(connTuple) {
+		conn = &snoopTCPConn{TCPConn: conn, snooper: h.dnsSnooper}
+	}
+	if h.isDNSHijacked(connTuple) {
+		tracked, conn := h.conns.addTCP(connTuple, conn)
+		tracked.setHijacked()
+		defer h.track(tracked)()
+		if err := dns.ServeConn(context.Background(), conn, h.dnsResolver); err != nil {
+			tracked.recordError(err)
+			h.reportError(adapter.ErrorDNS, connTuple, err)
+		}
+		return
+	}
+	if h.sniffTimeout > 0 {
+		var res sniff.Result
+		conn, res, connTuple.Protocol = sniffTCPConn(conn, h.sniffTimeout)
+		connTuple.Host, connTuple.ALPN = res.Host, strings.Join(res.ALPN, ",")
+	}
+	tracked, conn := h.conns.addTCP(connTuple, conn)
+	defer h.track(tracked)()
+	connHandler, tcpListener, _ := h.targets()
+	if tcpListener != nil {
+		tracked.setRoute("", tcpListener)
+		tcpListener.handle(connTuple, conn)
+	} else if connHandler != nil {
+		tracked.setRoute("", connHandler)
+		connHandler.HandleTCPConn(connTuple, conn)
 	}
 }
 
 func (h *tunTransportHandler) handleUDPConn(conn adapter.UDPConn) {
 	defer conn.Close()
+	defer h.recoverPanic(conn.ID())
 
-	connTuple := newConnTuple(conn.ID())
-	if h.connHandler != nil {
-		h.connHandler.HandleUDPConn(connTuple, conn)
+	connTuple := h.resolveConnTuple(conn.ID())
+	if h.isDNSSnooped(connTuple) {
+		conn = &snoopUDPConn{UDPConn: conn, snooper: h.dnsSnooper}
+	}
+	if h.isDNSHijacked(connTuple) {
+		tracked, conn := h.conns.addUDP(connTuple, conn)
+		tracked.setHijacked()
+		defer h.track(tracked)()
+		if err := dns.ServePacket(context.Background(), conn, h.dnsResolver); err != nil {
+			tracked.recordError(err)
+			h.reportError(adapter.ErrorDNS, connTuple, err)
+		}
+		return
+	}
+	if h.sniffTimeout > 0 {
+		var res sniff.Result
+		conn, res, connTuple.Protocol = sniffUDPSession(conn, h.sniffTimeout)
+		connTuple.Host, connTuple.ALPN = res.Host, strings.Join(res.ALPN, ",")
+	}
+	tracked, conn := h.conns.addUDP(connTuple, conn)
+	defer h.track(tracked)()
+	connHandler, _, udpListener := h.targets()
+	if udpListener != nil {
+		tracked.setRoute("", udpListener)
+		udpListener.handle(connTuple, conn)
+	} else if connHandler != nil {
+		tracked.setRoute("", connHandler)
+		connHandler.HandleUDPConn(connTuple, conn)
 	}
 }
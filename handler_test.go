@@ -0,0 +1,200 @@
+package netstackgo
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/josexy/netstackgo/accesslog"
+	"github.com/josexy/netstackgo/dns"
+	"github.com/josexy/netstackgo/tun"
+	"github.com/josexy/netstackgo/tun/core/adapter"
+	"golang.org/x/net/dns/dnsmessage"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+func TestReportEndpointError(t *testing.T) {
+	var logs bytes.Buffer
+	var gotKind adapter.ErrorKind
+	var gotTuple ConnTuple
+	ns := New(tun.TunConfig{},
+		WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+		WithErrorHandler(func(kind adapter.ErrorKind, tuple ConnTuple, err error) {
+			gotKind, gotTuple = kind, tuple
+		}),
+	)
+
+	id := &stack.TransportEndpointID{
+		LocalAddress:  tcpip.AddrFrom4([4]byte{1, 1, 1, 1}),
+		LocalPort:     443,
+		RemoteAddress: tcpip.AddrFrom4([4]byte{198, 18, 0, 1}),
+		RemotePort:    50000,
+	}
+	ns.handler.reportEndpointError(adapter.ErrorCreateEndpoint, id, errors.New("connection refused"))
+
+	if gotKind != adapter.ErrorCreateEndpoint {
+		t.Fatalf("kind = %q", gotKind)
+	}
+	if want := netip.MustParseAddrPort("1.1.1.1:443"); gotTuple.DstAddr != want {
+		t.Fatalf("dst = %s, want %s", gotTuple.DstAddr, want)
+	}
+	if !strings.Contains(logs.String(), "kind=create_endpoint") || !strings.Contains(logs.String(), "src=198.18.0.1:50000") {
+		t.Fatalf("unexpected log: %s", logs.String())
+	}
+
+	logs.Reset()
+	ns.handler.reportEndpointError(adapter.ErrorWritePacket, nil, errors.New("write failed"))
+	if gotTuple.SrcAddr.IsValid() || strings.Contains(logs.String(), "src=") {
+		t.Fatalf("unexpected tuple for device error: %+v, %s", gotTuple, logs.String())
+	}
+}
+
+type nopHandler struct{}
+
+func (nopHandler) HandleTCPConn(ConnTuple, net.Conn)       {}
+func (nopHandler) HandleUDPConn(ConnTuple, net.PacketConn) {}
+
+func TestAccessLogRecordsRule(t *testing.T) {
+	var buf bytes.Buffer
+	ns := New(tun.TunConfig{}, WithAccessLog(accesslog.New(&buf)))
+	router := NewRouter(nil, Rule{Name: "dns", Match: MatchDstPort(53), Handler: nopHandler{}})
+
+	connTuple := ConnTuple{
+		SrcAddr: netip.MustParseAddrPort("198.18.0.1:50000"),
+		DstAddr: netip.MustParseAddrPort("1.1.1.1:53"),
+		Domain:  "one.one.one.one",
+	}
+	tracked, conn := ns.handler.conns.addUDP(connTuple, nil)
+	tracked.down.Add(64)
+	router.HandleUDPConn(connTuple, conn)
+	ns.handler.logAccess(tracked)
+
+	var rec accesslog.Record
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Rule != "dns" || rec.Handler != "netstackgo.nopHandler" || rec.Proto != "udp" ||
+		rec.Domain != "one.one.one.one" || rec.BytesDown != 64 || rec.Reason != "closed" {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+}
+
+type panicHandler struct{}
+
+func (panicHandler) HandleTCPConn(ConnTuple, net.Conn)       { panic("tcp") }
+func (panicHandler) HandleUDPConn(ConnTuple, net.PacketConn) { panic("udp") }
+
+func TestHandlerPanicRecovered(t *testing.T) {
+	panics := make(chan ConnTuple, 2)
+	ns, client := startTestNetstack(t, WithErrorHandler(func(kind adapter.ErrorKind, tuple ConnTuple, err error) {
+		if kind == adapter.ErrorHandlerPanic {
+			panics <- tuple
+		}
+	}))
+	ns.RegisterConnHandler(panicHandler{})
+	dst := tcpip.FullAddress{NIC: 1, Addr: tcpip.AddrFrom4([4]byte{1, 1, 1, 1}), Port: 80}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := gonet.DialContextTCP(ctx, client, dst, ipv4.ProtocolNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	udpConn, err := gonet.DialUDP(client, nil, &dst, ipv4.ProtocolNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpConn.Close()
+	if _, err = udpConn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		select {
+		case tuple := <-panics:
+			if tuple.DstAddr != netip.MustParseAddrPort("1.1.1.1:80") {
+				t.Errorf("unexpected tuple %+v of the panic", tuple)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("the panic was not reported")
+		}
+	}
+	// the connection is closed and untracked after the panic
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err = conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("unexpected read error %v", err)
+	}
+	if conns := ns.Connections(); len(conns) != 0 {
+		t.Fatalf("unexpected connections %+v", conns)
+	}
+}
+
+func TestDNSHijackTracked(t *testing.T) {
+	var logs bytes.Buffer
+	hosts := dns.NewHosts(map[string][]netip.Addr{"example.com": {netip.MustParseAddr("1.2.3.4")}}, nil)
+	ns, client := startTestNetstack(t, WithDNSHijack(hosts), WithAccessLog(accesslog.New(&logs)))
+	sub := ns.Subscribe(32)
+	defer sub.Unsubscribe()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := gonet.DialContextTCP(ctx, client, tcpip.FullAddress{
+		NIC:  1,
+		Addr: tcpip.AddrFrom4([4]byte{1, 1, 1, 1}),
+		Port: 53,
+	}, ipv4.ProtocolNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, err := (&dnsmessage.Message{
+		Header: dnsmessage.Header{ID: 1, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  dnsmessage.MustNewName("example.com."),
+			Type:  dnsmessage.TypeA,
+			Class: dnsmessage.ClassINET,
+		}},
+	}).Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err = conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...)); err != nil {
+		t.Fatal(err)
+	}
+	var length [2]byte
+	if _, err = io.ReadFull(conn, length[:]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadFull(conn, make([]byte, binary.BigEndian.Uint16(length[:]))); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if opened := nextEvent[ConnOpened](t, sub); opened.Tuple.DstAddr.Port() != 53 {
+		t.Fatalf("unexpected %+v", opened)
+	}
+	closed := nextEvent[ConnClosed](t, sub)
+	if closed.BytesUp != uint64(len(query)+2) || closed.BytesDown == 0 {
+		t.Fatalf("unexpected %+v", closed)
+	}
+	var rec accesslog.Record
+	if err = json.Unmarshal(logs.Bytes(), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Rule != "dns-hijack" || rec.Handler != "dns-hijack" || rec.Proto != "tcp" || rec.Dst != "1.1.1.1:53" {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+}
@@ -0,0 +1,200 @@
+package netstackgo
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/josexy/netstackgo/tun/core/adapter"
+)
+
+var errListening = errors.New("tun netstack is already listening")
+
+var _ net.Listener = (*TCPListener)(nil)
+
+// Conn is a TCP connection accepted from the listener of ListenTCP.
+type Conn struct {
+	adapter.TCPConn
+	tuple     ConnTuple
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// Tuple returns the connection tuple of Conn.
+func (c *Conn) Tuple() ConnTuple { return c.tuple }
+
+func (c *Conn) Close() error {
+	err := c.TCPConn.Close()
+	c.closeOnce.Do(func() { close(c.done) })
+	return err
+}
+
+func (c *Conn) Abort() error {
+	err := c.TCPConn.Abort()
+	c.closeOnce.Do(func() { close(c.done) })
+	return err
+}
+
+// PacketConn is a UDP session accepted from the UDPListener.
+type PacketConn struct {
+	adapter.UDPConn
+	tuple     ConnTuple
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// Tuple returns the connection tuple of PacketConn.
+func (c *PacketConn) Tuple() ConnTuple { return c.tuple }
+
+func (c *PacketConn) Close() error {
+	err := c.UDPConn.Close()
+	c.closeOnce.Do(func() { close(c.done) })
+	return err
+}
+
+// listener is the common part of the TCP and UDP listeners. The handler
+// goroutine of a connection blocks until the connection is accepted and
+// then closed by the user, so that the connection stays tracked.
+type listener[T any] struct {
+	addr      net.Addr
+	acceptCh  chan T
+	closeCh   chan struct{}
+	closeOnce sync.Once
+	onClose   func()
+}
+
+func newListener[T any](addr net.Addr, onClose func()) *listener[T] {
+	return &listener[T]{
+		addr:     addr,
+		acceptCh: make(chan T),
+		closeCh:  make(chan struct{}),
+		onClose:  onClose,
+	}
+}
+
+// deliver hands conn to Accept, and returns false if the listener is closed.
+func (l *listener[T]) deliver(conn T) bool {
+	select {
+	case l.acceptCh <- conn:
+		return true
+	case <-l.closeCh:
+		return false
+	}
+}
+
+func (l *listener[T]) accept() (T, error) {
+	select {
+	case conn := <-l.acceptCh:
+		return conn, nil
+	case <-l.closeCh:
+		var zero T
+		return zero, &net.OpError{Op: "accept", Net: l.addr.Network(), Addr: l.addr, Err: net.ErrClosed}
+	}
+}
+
+// Close unblocks Accept, the connections accepted before are not closed.
+func (l *listener[T]) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closeCh)
+		l.onClose()
+	})
+	return nil
+}
+
+func (l *listener[T]) Addr() net.Addr { return l.addr }
+
+// TCPListener yields every TCP connection forwarded by the netstack.
+type TCPListener struct {
+	*listener[*Conn]
+}
+
+// Accept waits for and returns the next connection as *Conn.
+func (l *TCPListener) Accept() (net.Conn, error) {
+	return l.AcceptConn()
+}
+
+// AcceptConn waits for and returns the next connection.
+func (l *TCPListener) AcceptConn() (*Conn, error) {
+	return l.accept()
+}
+
+func (l *TCPListener) handle(connTuple ConnTuple, conn adapter.TCPConn) {
+	c := &Conn{TCPConn: conn, tuple: connTuple, done: make(chan struct{})}
+	if l.deliver(c) {
+		<-c.done
+	}
+}
+
+// UDPListener yields every UDP session forwarded by the netstack.
+type UDPListener struct {
+	*listener[*PacketConn]
+}
+
+// Accept waits for and returns the next UDP session.
+func (l *UDPListener) Accept() (*PacketConn, error) {
+	return l.accept()
+}
+
+func (l *UDPListener) handle(connTuple ConnTuple, conn adapter.UDPConn) {
+	c := &PacketConn{UDPConn: conn, tuple: connTuple, done: make(chan struct{})}
+	if l.deliver(c) {
+		<-c.done
+	}
+}
+
+// closeListeners closes the listeners of ListenTCP and ListenUDP.
+func (h *tunTransportHandler) closeListeners() {
+	_, tcpListener, udpListener := h.targets()
+	if tcpListener != nil {
+		tcpListener.Close()
+	}
+	if udpListener != nil {
+		udpListener.Close()
+	}
+}
+
+// ListenTCP returns a listener yielding every forwarded TCP connection
+// instead of passing them to the ConnHandler, until the listener or the
+// netstack is closed.
+func (ns *TunNetstack) ListenTCP() (*TCPListener, error) {
+	h := ns.handler
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.tcpListener != nil {
+		return nil, errListening
+	}
+	addr := &net.TCPAddr{IP: ns.tunAddr().AsSlice()}
+	var l *TCPListener
+	l = &TCPListener{newListener[*Conn](addr, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.tcpListener == l {
+			h.tcpListener = nil
+		}
+	})}
+	h.tcpListener = l
+	return l, nil
+}
+
+// ListenUDP returns a listener yielding every forwarded UDP session instead
+// of passing them to the ConnHandler, until the listener or the netstack is
+// closed.
+func (ns *TunNetstack) ListenUDP() (*UDPListener, error) {
+	h := ns.handler
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.udpListener != nil {
+		return nil, errListening
+	}
+	addr := &net.UDPAddr{IP: ns.tunAddr().AsSlice()}
+	var l *UDPListener
+	l = &UDPListener{newListener[*PacketConn](addr, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.udpListener == l {
+			h.udpListener = nil
+		}
+	})}
+	h.udpListener = l
+	return l, nil
+}
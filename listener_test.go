@@ -0,0 +1,92 @@
+package netstackgo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// httpGet requests url from the TUN client stack s.
+func httpGet(t *testing.T, s *stack.Stack, url string) string {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, address string) (net.Conn, error) {
+				addr := netip.MustParseAddrPort(address)
+				return gonet.DialContextTCP(ctx, s, tcpip.FullAddress{
+					NIC:  1,
+					Addr: tcpip.AddrFrom4(addr.Addr().As4()),
+					Port: addr.Port(),
+				}, ipv4.ProtocolNumber)
+			},
+		},
+		Timeout: 5 * time.Second,
+	}
+	defer client.CloseIdleConnections()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestTCPListenerServeHTTP(t *testing.T) {
+	ns, client := startTestNetstack(t)
+	ln, err := ns.ListenTCP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, r.Host)
+		}))
+	}()
+
+	// every destination is accepted by the listener
+	for _, host := range []string{"1.1.1.1", "8.8.8.8:8080"} {
+		if body := httpGet(t, client, "http://"+host+"/"); body != host {
+			t.Errorf("unexpected body %q for %s", body, host)
+		}
+	}
+
+	if err = ns.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-served:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Fatalf("unexpected serve error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close didn't close the listener")
+	}
+}
+
+func TestCloseClosesUDPListener(t *testing.T) {
+	ns, _ := startTestNetstack(t)
+	ln, err := ns.ListenUDP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ns.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ln.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("unexpected accept error %v", err)
+	}
+}
@@ -0,0 +1,226 @@
+package netstackgo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/josexy/netstackgo/sniff"
+)
+
+// durationBuckets is the upper bounds in seconds of the connection handling
+// duration histogram.
+var durationBuckets = []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300, 1800}
+
+type histogram struct {
+	bounds []float64
+	counts []uint64 // non-cumulative, the last one is +Inf
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	i, _ := slices.BinarySearch(h.bounds, v)
+	h.counts[i]++
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) clone() *histogram {
+	c := *h
+	c.counts = slices.Clone(h.counts)
+	return &c
+}
+
+// connMetrics is the snapshot of the connection counters of a network.
+type connMetrics struct {
+	active    map[sniff.Protocol]int
+	opened    uint64
+	bytesUp   uint64
+	bytesDown uint64
+	durations *histogram
+}
+
+// metrics returns the connection counters keyed by network, the bytes
+// include the active connections.
+func (t *connTable) metrics() map[string]*connMetrics {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	metrics := make(map[string]*connMetrics, len(t.totals))
+	for network, totals := range t.totals {
+		metrics[network] = &connMetrics{
+			active:    make(map[sniff.Protocol]int),
+			opened:    totals.opened,
+			bytesUp:   totals.bytesUp,
+			bytesDown: totals.bytesDown,
+			durations: totals.durations.clone(),
+		}
+	}
+	for _, c := range t.conns {
+		m := metrics[c.network]
+		m.active[c.tuple.Protocol]++
+		m.bytesUp += c.up.Load()
+		m.bytesDown += c.down.Load()
+	}
+	return metrics
+}
+
+// MetricsHandler returns the http.Handler serving the connection, device
+// and netstack counters in the Prometheus text exposition format.
+func (ns *TunNetstack) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		ns.writeMetrics(bw)
+		_ = bw.Flush()
+	})
+}
+
+func (ns *TunNetstack) writeMetrics(w io.Writer) {
+	mw := &metricsWriter{w: w}
+	networks := []string{"tcp", "udp"}
+	conns := ns.handler.conns.metrics()
+
+	mw.header("netstack_connections_active", "gauge", "Active connections by network and sniffed protocol.")
+	for _, network := range networks {
+		active := conns[network].active
+		protocols := make([]sniff.Protocol, 0, len(active))
+		for protocol := range active {
+			protocols = append(protocols, protocol)
+		}
+		slices.Sort(protocols)
+		for _, protocol := range protocols {
+			name := string(protocol)
+			if name == "" {
+				name = "unknown"
+			}
+			mw.sample("netstack_connections_active", labels("network", network, "protocol", name), float64(active[protocol]))
+		}
+	}
+
+	mw.header("netstack_connections_total", "counter", "Connections handled since start.")
+	for _, network := range networks {
+		mw.sample("netstack_connections_total", labels("network", network), float64(conns[network].opened))
+	}
+
+	mw.header("netstack_connection_bytes_total", "counter", "Bytes read from (up) and written to (down) the TUN clients.")
+	for _, network := range networks {
+		mw.sample("netstack_connection_bytes_total", labels("network", network, "direction", "up"), float64(conns[network].bytesUp))
+		mw.sample("netstack_connection_bytes_total", labels("network", network, "direction", "down"), float64(conns[network].bytesDown))
+	}
+
+	mw.header("netstack_handler_duration_seconds", "histogram", "Time spent handling a connection until it's closed.")
+	for _, network := range networks {
+		h := conns[network].durations
+		var cumulative uint64
+		for i, bound := range h.bounds {
+			cumulative += h.counts[i]
+			mw.sample("netstack_handler_duration_seconds_bucket", labels("network", network, "le", formatFloat(bound)), float64(cumulative))
+		}
+		mw.sample("netstack_handler_duration_seconds_bucket", labels("network", network, "le", "+Inf"), float64(h.count))
+		mw.sample("netstack_handler_duration_seconds_sum", labels("network", network), h.sum)
+		mw.sample("netstack_handler_duration_seconds_count", labels("network", network), float64(h.count))
+	}
+
+	mw.header("netstack_handler_queue_depth", "gauge", "Connections waiting to be dispatched to the handler.")
+	mw.sample("netstack_handler_queue_depth", labels("network", "tcp"), float64(len(ns.handler.tcpQueue)))
+	mw.sample("netstack_handler_queue_depth", labels("network", "udp"), float64(len(ns.handler.udpQueue)))
+
+	stats := ns.Stats()
+	mw.header("netstack_device_packets_total", "counter", "Packets read from and written to the TUN device.")
+	mw.sample("netstack_device_packets_total", labels("direction", "read"), float64(stats.Device.PacketsRead))
+	mw.sample("netstack_device_packets_total", labels("direction", "written"), float64(stats.Device.PacketsWritten))
+	mw.header("netstack_device_dropped_packets_total", "counter", "Packets dropped by the TUN device.")
+	mw.sample("netstack_device_dropped_packets_total", labels("reason", "oversize"), float64(stats.Device.OversizeDrops))
+	mw.sample("netstack_device_dropped_packets_total", labels("reason", "queue_full"), float64(stats.Device.QueueDrops))
+	mw.header("netstack_device_write_errors_total", "counter", "Packets failed to write to the TUN device.")
+	mw.sample("netstack_device_write_errors_total", "", float64(stats.Device.WriteErrors))
+
+	mw.stats("netstack_ip", stats.IP)
+	mw.stats("netstack_tcp", stats.TCP)
+	mw.stats("netstack_udp", stats.UDP)
+	mw.stats("netstack_icmp", stats.ICMP)
+}
+
+type metricsWriter struct {
+	w io.Writer
+}
+
+func (mw *metricsWriter) header(name, typ, help string) {
+	fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (mw *metricsWriter) sample(name, labels string, value float64) {
+	fmt.Fprintf(mw.w, "%s%s %s\n", name, labels, formatFloat(value))
+}
+
+// stats writes the uint64 fields of the stats struct as the counters, or
+// gauges if tagged, named prefix_field_name.
+func (mw *metricsWriter) stats(prefix string, stats any) {
+	v := reflect.ValueOf(stats)
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name := prefix + "_" + snakeCase(field.Name)
+		if field.Tag.Get("stats") == "gauge" {
+			mw.header(name, "gauge", "gVisor netstack "+field.Name+".")
+		} else {
+			name += "_total"
+			mw.header(name, "counter", "gVisor netstack "+field.Name+".")
+		}
+		mw.sample(name, "", float64(v.Field(i).Uint()))
+	}
+}
+
+// labels formats the label pairs of name and value.
+func labels(pairs ...string) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(pairs[i])
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(pairs[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// snakeCase converts the Go field name to snake case, e.g. "SegmentsSent"
+// to "segments_sent".
+func snakeCase(s string) string {
+	var b strings.Builder
+	runes := []rune(s)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,48 @@
+package netstackgo
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/josexy/netstackgo/tun"
+)
+
+func TestSnakeCase(t *testing.T) {
+	for in, want := range map[string]string{
+		"SegmentsSent":          "segments_sent",
+		"ListenOverflowSynDrop": "listen_overflow_syn_drop",
+		"FastRetransmit":        "fast_retransmit",
+	} {
+		if got := snakeCase(in); got != want {
+			t.Errorf("snakeCase(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	ns := New(tun.TunConfig{Addr: "198.18.0.1/16"})
+	tracked, _ := ns.handler.conns.addTCP(ConnTuple{Protocol: "tls"}, nil)
+	tracked.up.Add(100)
+	tracked, _ = ns.handler.conns.addUDP(ConnTuple{}, nil)
+	tracked.down.Add(7)
+	ns.handler.conns.remove(tracked)
+
+	rec := httptest.NewRecorder()
+	ns.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`netstack_connections_active{network="tcp",protocol="tls"} 1`,
+		`netstack_connections_total{network="udp"} 1`,
+		`netstack_connection_bytes_total{network="tcp",direction="up"} 100`,
+		`netstack_connection_bytes_total{network="udp",direction="down"} 7`,
+		`netstack_handler_duration_seconds_bucket{network="udp",le="+Inf"} 1`,
+		`netstack_handler_queue_depth{network="tcp"} 0`,
+		"# TYPE netstack_tcp_current_established gauge",
+		"netstack_tcp_segments_sent_total 0",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+}
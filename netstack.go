@@ -3,9 +3,12 @@ package netstackgo
 import (
 	"errors"
 	"net/netip"
+	"time"
 
+	"github.com/josexy/netstackgo/fakeip"
 	"github.com/josexy/netstackgo/tun"
 	"github.com/josexy/netstackgo/tun/core"
+	"github.com/josexy/netstackgo/tun/core/adapter"
 	"github.com/josexy/netstackgo/tun/core/device"
 	T "github.com/josexy/netstackgo/tun/core/device/tun"
 	"github.com/josexy/netstackgo/tun/core/option"
@@ -30,34 +33,115 @@ var defaultCIDRRoutes = []string{
 }
 
 type TunNetstack struct {
-	netstack  *stack.Stack
-	tunDevice device.Device
-	tunCfg    tun.TunConfig
-	handler   *tunTransportHandler
-	running   bool
+	netstack   *stack.Stack
+	tunDevice  device.Device
+	tunCfg     tun.TunConfig
+	handler    *tunTransportHandler
+	fakeIPPool *fakeip.Pool
+	routes     []tun.IPRoute
+	groTimeout time.Duration
+	running    bool
+
+	// customDevice opens the device set by WithTunFD or WithDevice.
+	customDevice func() (device.Device, error)
+	// host configures the address and the routes of the TUN device.
+	host hostConfigurator
+}
+
+// hostConfigurator configures the TUN device on the host, it's replaced in
+// tests to avoid touching the host.
+type hostConfigurator interface {
+	SetTunAddress(name, addr string, mtu uint32) error
+	AddTunRoutes(name string, routes []tun.IPRoute) error
+	DelTunRoutes(name string, routes []tun.IPRoute) error
+}
+
+// systemHost configures the TUN device with the system commands.
+type systemHost struct{}
+
+func (systemHost) SetTunAddress(name, addr string, mtu uint32) error {
+	return tun.SetTunAddress(name, addr, mtu)
+}
+
+func (systemHost) AddTunRoutes(name string, routes []tun.IPRoute) error {
+	return tun.AddTunRoutes(name, routes)
 }
 
-func New(tunCfg tun.TunConfig) *TunNetstack {
-	return &TunNetstack{
+func (systemHost) DelTunRoutes(name string, routes []tun.IPRoute) error {
+	return tun.DelTunRoutes(name, routes)
+}
+
+func New(tunCfg tun.TunConfig, opts ...Option) *TunNetstack {
+	ns := &TunNetstack{
 		tunCfg:  tunCfg,
 		handler: newTunTransportHandler(),
+		host:    systemHost{},
 		running: false,
 	}
+	for _, opt := range opts {
+		opt(ns)
+	}
+	return ns
 }
 
 func (ns *TunNetstack) Start() (err error) {
 	if ns.running {
 		return errors.New("tun netstack is running")
 	}
+	// reload the fake ip address table before touching the host, so that a
+	// broken snapshot doesn't leave the routes pointing at a dead device
+	if ns.fakeIPPool != nil {
+		if err = ns.fakeIPPool.Load(); err != nil {
+			return
+		}
+	}
+
 	// create tun device
-	if ns.tunDevice, err = T.Open(ns.tunCfg.Name, ns.tunCfg.MTU); err != nil {
+	if ns.tunDevice, err = ns.openDevice(); err != nil {
 		return
 	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, ns.rollback())
+		}
+	}()
+	ns.tunDevice.SetErrorHandler(ns.reportDeviceError)
 
-	// setup ip address for tun device
-	if err = tun.SetTunAddress(ns.tunCfg.Name, ns.tunCfg.Addr, ns.tunCfg.MTU); err != nil {
+	if !ns.tunCfg.SkipSetup {
+		if err = ns.setupHost(); err != nil {
+			return
+		}
+	}
+
+	ns.handler.run()
+
+	// init gVisor netstack
+	if err = ns.createStack(); err != nil {
+		ns.handler.finish()
 		return
 	}
+	ns.running = true
+	return
+}
+
+// openDevice opens the custom device if it's set, or creates the TUN
+// device.
+func (ns *TunNetstack) openDevice() (device.Device, error) {
+	if ns.customDevice != nil {
+		return ns.customDevice()
+	}
+	return T.Open(ns.tunCfg.Name, ns.tunCfg.MTU,
+		T.WithOffload(!ns.tunCfg.DisableOffload),
+		T.WithQueues(ns.tunCfg.Queues),
+	)
+}
+
+// setupHost sets up the address and the routes of the TUN device.
+func (ns *TunNetstack) setupHost() error {
+	// setup ip address for tun device
+	if err := ns.host.SetTunAddress(ns.tunCfg.Name, ns.tunCfg.Addr, ns.tunCfg.MTU); err != nil {
+		return err
+	}
 
 	tunSubnet := netip.MustParsePrefix(ns.tunCfg.Addr)
 	var routes []tun.IPRoute
@@ -69,28 +153,45 @@ func (ns *TunNetstack) Start() (err error) {
 	}
 
 	// setup local route table
-	if err = tun.AddTunRoutes(ns.tunCfg.Name, routes); err != nil {
-		return
+	if err := ns.host.AddTunRoutes(ns.tunCfg.Name, routes); err != nil {
+		return err
 	}
+	ns.routes = routes
+	ns.publish(RoutesApplied{eventTime: eventTime(time.Now()), Name: ns.tunCfg.Name, Routes: routes})
+	return nil
+}
 
-	ns.handler.run()
-
-	// init gVisor netstack
-	if err = ns.createStack(); err != nil {
-		return
+// rollback removes the routes added and closes the device opened by a
+// failed Start.
+func (ns *TunNetstack) rollback() error {
+	var err error
+	if ns.routes != nil {
+		err = ns.host.DelTunRoutes(ns.tunCfg.Name, ns.routes)
+		ns.routes = nil
 	}
-	ns.running = true
-	return
+	return errors.Join(err, ns.tunDevice.Close())
 }
 
 func (ns *TunNetstack) Close() error {
 	if !ns.running {
 		return errors.New("tun netstack was stopped")
 	}
-	err := ns.tunDevice.Close()
+	ns.running = false
+	var err error
+	if !ns.tunCfg.SkipSetup {
+		err = ns.host.DelTunRoutes(ns.tunCfg.Name, ns.routes)
+		ns.publish(RoutesRemoved{eventTime: eventTime(time.Now()), Name: ns.tunCfg.Name, Routes: ns.routes, Err: err})
+		ns.routes = nil
+	}
+	err = errors.Join(err, ns.tunDevice.Close())
+	ns.publish(DeviceDown{eventTime: eventTime(time.Now()), Name: ns.tunCfg.Name})
+	ns.handler.closeListeners()
 	ns.handler.finish()
 	ns.netstack.Close()
 	ns.netstack.Wait()
+	if ns.fakeIPPool != nil {
+		err = errors.Join(err, ns.fakeIPPool.Save())
+	}
 	return err
 }
 
@@ -98,6 +199,31 @@ func (ns *TunNetstack) RegisterConnHandler(handler ConnHandler) {
 	ns.handler.registerConnHandler(handler)
 }
 
+func (ns *TunNetstack) publish(ev Event) {
+	ns.handler.events.publish(ev)
+}
+
+// reportDeviceError reports the errors of the TUN device, the device is down
+// if it fails to read packets.
+func (ns *TunNetstack) reportDeviceError(kind adapter.ErrorKind, id *stack.TransportEndpointID, err error) {
+	ns.handler.reportEndpointError(kind, id, err)
+	if kind == adapter.ErrorReadPacket {
+		ns.publish(DeviceDown{eventTime: eventTime(time.Now()), Name: ns.tunCfg.Name, Err: err})
+	}
+}
+
+// tunAddr returns the address of the tun device.
+func (ns *TunNetstack) tunAddr() netip.Addr {
+	prefix, _ := netip.ParsePrefix(ns.tunCfg.Addr)
+	return prefix.Addr()
+}
+
+// Connections returns the snapshot of the connections being handled by
+// ConnHandler, ordered by their IDs.
+func (ns *TunNetstack) Connections() []ConnInfo {
+	return ns.handler.conns.snapshot()
+}
+
 func (ns *TunNetstack) createStack() error {
 	ns.netstack = stack.New(stack.Options{
 		NetworkProtocols: []stack.NetworkProtocolFactory{
@@ -121,8 +247,8 @@ func (ns *TunNetstack) createStack() error {
 		// before creating NIC, otherwise NIC would dispatch packets
 		// to stack and cause race condition.
 		// Initiate transport protocol (TCP/UDP) with given handler.
-		core.WithTCPHandler(ns.handler.HandleTCP),
-		core.WithUDPHandler(ns.handler.HandleUDP),
+		core.WithTCPHandler(ns.handler.HandleTCP, ns.handler.reportEndpointError),
+		core.WithUDPHandler(ns.handler.HandleUDP, ns.handler.reportEndpointError),
 
 		// Create stack NIC and then bind link endpoint to it.
 		core.WithCreatingNIC(nicID, ns.tunDevice),
@@ -155,6 +281,10 @@ func (ns *TunNetstack) createStack() error {
 		core.WithRouteTable(nicID),
 	)
 
+	if ns.groTimeout > 0 {
+		opts = append(opts, core.WithGRO(nicID, ns.groTimeout))
+	}
+
 	for _, opt := range opts {
 		if err := opt(ns.netstack); err != nil {
 			return err
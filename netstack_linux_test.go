@@ -0,0 +1,39 @@
+package netstackgo
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/josexy/netstackgo/tun"
+	"golang.org/x/sys/unix"
+)
+
+func TestStartWithTunFD(t *testing.T) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := os.NewFile(uintptr(fds[1]), "peer")
+	defer peer.Close()
+
+	ns := New(tun.TunConfig{Addr: "198.18.0.1/16", MTU: 1500, SkipSetup: true}, WithTunFD(fds[0]))
+	sub := ns.Subscribe(8)
+	defer sub.Unsubscribe()
+	if err = ns.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if name := ns.tunDevice.Name(); name != "fd"+strconv.Itoa(fds[0]) {
+		t.Errorf("device name = %q", name)
+	}
+	if err = ns.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for len(sub.C()) > 0 {
+		switch ev := (<-sub.C()).(type) {
+		case RoutesApplied, RoutesRemoved:
+			t.Errorf("unexpected %T with SkipSetup", ev)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package netstackgo
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/josexy/netstackgo/fakeip"
+	"github.com/josexy/netstackgo/tun"
+	"github.com/josexy/netstackgo/tun/core"
+	"github.com/josexy/netstackgo/tun/core/device"
+	"github.com/josexy/netstackgo/tun/core/device/generic"
+	"github.com/josexy/netstackgo/tun/core/option"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+)
+
+// testClientAddr is the address of the TUN client of startTestNetstack.
+var testClientAddr = netip.MustParseAddr("198.18.0.2")
+
+// startTestNetstack starts a netstack on a framed pipe device instead of a
+// TUN device, the other end of which is attached to a gVisor stack standing
+// in for the TUN client at testClientAddr.
+func startTestNetstack(t *testing.T, opts ...Option) (*TunNetstack, *stack.Stack) {
+	local, remote := net.Pipe()
+	dev, err := generic.NewFramed("pipe", local, 1500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns := New(tun.TunConfig{Addr: "198.18.0.1/16", MTU: 1500, SkipSetup: true}, append(opts, WithDevice(dev))...)
+	if err = ns.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if ns.running {
+			ns.Close()
+		}
+	})
+
+	clientDev, err := generic.NewFramed("client", remote, 1500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol},
+	})
+	t.Cleanup(func() {
+		clientDev.Close()
+		client.Close()
+		client.Wait()
+	})
+	for _, opt := range []option.Option{
+		option.WithDefault(),
+		core.WithCreatingNIC(1, clientDev),
+		core.WithRouteTable(1),
+	} {
+		if err = opt(client); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.AddProtocolAddress(1, tcpip.ProtocolAddress{
+		Protocol:          ipv4.ProtocolNumber,
+		AddressWithPrefix: tcpip.AddrFrom4(testClientAddr.As4()).WithPrefix(),
+	}, stack.AddressProperties{}); err != nil {
+		t.Fatal(err)
+	}
+	return ns, client
+}
+
+func TestStartWithDevice(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	dev, err := generic.NewFramed("pipe", local, 1500)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ns := New(tun.TunConfig{Addr: "198.18.0.1/16", MTU: 1500, SkipSetup: true}, WithDevice(dev))
+	if err = ns.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if ns.tunDevice != dev {
+		t.Error("the device is not used")
+	}
+	if err = ns.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStartLoadsFakeIPBeforeDevice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fakeip.json")
+	if err := os.WriteFile(path, []byte("{broken"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pool, err := fakeip.New(netip.MustParsePrefix("198.18.0.0/16"), fakeip.NewFileStore(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ns := New(tun.TunConfig{Addr: "10.0.0.1/24", MTU: 1500, SkipSetup: true}, WithFakeIP(pool))
+	opened := false
+	ns.customDevice = func() (device.Device, error) {
+		opened = true
+		return nil, errors.New("unexpected open")
+	}
+	if err = ns.Start(); err == nil {
+		t.Fatal("Start succeeded with a broken snapshot")
+	}
+	if opened {
+		t.Error("the device was opened before the snapshot was loaded")
+	}
+}
+
+func TestRestartAfterClose(t *testing.T) {
+	ns := New(tun.TunConfig{Addr: "198.18.0.1/16", MTU: 1500, SkipSetup: true})
+	ns.customDevice = func() (device.Device, error) {
+		local, remote := net.Pipe()
+		t.Cleanup(func() { remote.Close() })
+		return generic.NewFramed("pipe", local, 1500)
+	}
+	for i := 0; i < 2; i++ {
+		if err := ns.Start(); err != nil {
+			t.Fatalf("start %d: %v", i, err)
+		}
+		if err := ns.Close(); err != nil {
+			t.Fatalf("close %d: %v", i, err)
+		}
+	}
+	if err := ns.Close(); err == nil {
+		t.Fatal("Close succeeded on a stopped netstack")
+	}
+}
@@ -0,0 +1,114 @@
+package netstackgo
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/josexy/netstackgo/accesslog"
+	"github.com/josexy/netstackgo/dns"
+	"github.com/josexy/netstackgo/fakeip"
+	"github.com/josexy/netstackgo/tun/core/device"
+	T "github.com/josexy/netstackgo/tun/core/device/tun"
+)
+
+// Option configures the optional features of TunNetstack.
+type Option func(*TunNetstack)
+
+// WithFakeIP enables the fake ip address table. The table is reloaded from
+// the pool's store on Start and snapshotted on Close, and connections to a
+// fake ip address carry the resolved domain in ConnTuple.Domain.
+func WithFakeIP(pool *fakeip.Pool) Option {
+	return func(ns *TunNetstack) {
+		ns.fakeIPPool = pool
+		ns.handler.fakeIPPool = pool
+	}
+}
+
+// WithDNSHijack intercepts the UDP and TCP traffic to port 53 of any address
+// and answers the queries with resolver, instead of passing them to the
+// ConnHandler.
+func WithDNSHijack(resolver dns.Resolver) Option {
+	return func(ns *TunNetstack) {
+		ns.handler.dnsResolver = resolver
+	}
+}
+
+// WithDNSSnooping records the answers of the DNS responses written back to
+// the clients on port 53, so that later connections to the answered
+// addresses carry the queried name in ConnTuple.Domain.
+func WithDNSSnooping(snooper *dns.Snooper) Option {
+	return func(ns *TunNetstack) {
+		ns.handler.dnsSnooper = snooper
+	}
+}
+
+// WithSniffing peeks at the first client bytes of each TCP connection for at
+// most timeout, and fills ConnTuple.Host and ConnTuple.ALPN from the TLS
+// ClientHello or HTTP/1 request header. UDP sessions are sniffed for the
+// ClientHello in QUIC Initial packets likewise. The peeked bytes are still
+// delivered to the ConnHandler. Note that the protocols in which the server
+// speaks first are delayed by timeout.
+func WithSniffing(timeout time.Duration) Option {
+	return func(ns *TunNetstack) {
+		ns.handler.sniffTimeout = timeout
+	}
+}
+
+// WithLogger logs the errors reported to the ErrorHandler, and the opened
+// and closed connections at debug level.
+func WithLogger(logger *slog.Logger) Option {
+	return func(ns *TunNetstack) {
+		ns.handler.logger = logger
+	}
+}
+
+// WithErrorHandler sets the callback of the errors which are otherwise
+// silently dropped, e.g. failing to create the endpoint of a connection or
+// to write a packet to the TUN device. It must not block.
+func WithErrorHandler(onError ErrorHandler) Option {
+	return func(ns *TunNetstack) {
+		ns.handler.onError = onError
+	}
+}
+
+// WithAccessLog writes a record for each finished connection to logger,
+// including the connections of ListenTCP and ListenUDP. Use
+// accesslog.OpenRotatingFile for a size-rotated log file.
+func WithAccessLog(logger *accesslog.Logger) Option {
+	return func(ns *TunNetstack) {
+		ns.handler.accessLog = logger
+	}
+}
+
+// WithGRO coalesces the inbound TCP segments of a flow for at most timeout
+// before they're processed by the netstack, which saves the per-segment cost
+// of bulk TCP transfers, especially when the segments are split from a
+// large read with offload, at the cost of latency.
+func WithGRO(timeout time.Duration) Option {
+	return func(ns *TunNetstack) {
+		ns.groTimeout = timeout
+	}
+}
+
+// WithTunFD runs the netstack on the device of the inherited file descriptor
+// fd instead of creating the TUN device, see T.OpenFD for opts. TunConfig.Name
+// must be the name of the device unless TunConfig.SkipSetup is set.
+func WithTunFD(fd int, opts ...T.Option) Option {
+	return func(ns *TunNetstack) {
+		ns.customDevice = func() (device.Device, error) {
+			return T.OpenFD(fd, ns.tunCfg.MTU, opts...)
+		}
+	}
+}
+
+// WithDevice runs the netstack on dev instead of creating the TUN device,
+// e.g. a device of package generic driven by another process or a remote
+// peer. TunConfig.SkipSetup must be set unless dev is a TUN device named
+// TunConfig.Name. dev is closed by Close.
+func WithDevice(dev device.Device) Option {
+	return func(ns *TunNetstack) {
+		ns.customDevice = func() (device.Device, error) {
+			return dev, nil
+		}
+	}
+}
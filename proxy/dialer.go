@@ -0,0 +1,111 @@
+// Package proxy provides the ConnHandler implementations relaying the
+// connections of the netstack through proxy servers.
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/josexy/netstackgo"
+	"github.com/josexy/netstackgo/bind"
+	"github.com/josexy/netstackgo/iface"
+	"github.com/josexy/netstackgo/tun/core/adapter"
+)
+
+const (
+	// defaultDialTimeout is the timeout of connecting to the proxy server.
+	defaultDialTimeout = 10 * time.Second
+	// defaultTCPIdleTimeout is the idle timeout of the relayed TCP
+	// connections.
+	defaultTCPIdleTimeout = 5 * time.Minute
+)
+
+// dialer dials the proxy server through the outbound interface, so that
+// the connections to the proxy server don't loop back to the tun device.
+type dialer struct {
+	ifaceName string
+	timeout   time.Duration
+	resolver  *net.Resolver
+}
+
+func newDialer(ifaceName string, timeout time.Duration, resolver *net.Resolver) *dialer {
+	if timeout <= 0 {
+		timeout = defaultDialTimeout
+	}
+	return &dialer{ifaceName: ifaceName, timeout: timeout, resolver: resolver}
+}
+
+func (d *dialer) interfaceName() (string, error) {
+	if d.ifaceName != "" {
+		return d.ifaceName, nil
+	}
+	return iface.DefaultRouteInterface()
+}
+
+// resolve resolves the host of address into an ip address. A host name is
+// only resolved with the configured resolver, the system resolver may send
+// the queries through the tun device and loop back to the proxy itself.
+func (d *dialer) resolve(ctx context.Context, network, address string) (netip.AddrPort, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	addr, err := net.DefaultResolver.LookupPort(ctx, network, port)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		if d.resolver == nil {
+			return netip.AddrPort{}, fmt.Errorf("%s is not an ip address and no resolver is configured", host)
+		}
+		ips, err := d.resolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			return netip.AddrPort{}, err
+		}
+		ip = ips[0]
+	}
+	return netip.AddrPortFrom(ip.Unmap(), uint16(addr)), nil
+}
+
+func (d *dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	dst, err := d.resolve(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	name, err := d.interfaceName()
+	if err != nil {
+		return nil, err
+	}
+	var nd net.Dialer
+	// bind an outbound interface to avoid routing loops
+	if err = bind.BindToDeviceForConn(name, &nd, network, dst.Addr()); err != nil {
+		return nil, err
+	}
+	return nd.DialContext(ctx, network, dst.String())
+}
+
+func (d *dialer) ListenPacket(ctx context.Context, network, address string) (net.PacketConn, error) {
+	name, err := d.interfaceName()
+	if err != nil {
+		return nil, err
+	}
+	var lc net.ListenConfig
+	if address, err = bind.BindToDeviceForPacket(name, &lc, network, address); err != nil {
+		return nil, err
+	}
+	return lc.ListenPacket(ctx, network, address)
+}
+
+// reportError reports the failure of relaying the connection to onError if
+// it's set.
+func reportError(onError netstackgo.ErrorHandler, connTuple netstackgo.ConnTuple, err error) {
+	if onError != nil {
+		onError(adapter.ErrorProxy, connTuple, err)
+	}
+}
@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/josexy/netstackgo"
+	"github.com/josexy/netstackgo/relay"
+)
+
+// HTTPConfig is the configuration of the HTTP CONNECT outbound handler.
+type HTTPConfig struct {
+	// Server is the address (host:port) of the HTTP proxy server.
+	Server string
+	// Username and Password enable the Basic authentication if Username
+	// is not empty.
+	Username string
+	Password string
+	// TLS enables TLS to the proxy server with TLSConfig, the server name
+	// defaults to the host of Server.
+	TLS       bool
+	TLSConfig *tls.Config
+	// Header is the extra header sent in each CONNECT request.
+	Header http.Header
+	// Interface is the outbound interface to reach the server, the
+	// default route interface is used if empty.
+	Interface string
+	// Timeout is the timeout of connecting and handshaking with the
+	// server.
+	Timeout time.Duration
+	// Resolver resolves the host of Server if it's not an ip address, a
+	// literal ip address is required if nil. It must not send the queries
+	// through the tun device, e.g. its Dial should be bound to Interface.
+	Resolver *net.Resolver
+	// IdleTimeout closes a relayed TCP connection, half-closed or not, if
+	// no data is relayed in either direction for this duration. It
+	// defaults to 5 minutes.
+	IdleTimeout time.Duration
+	// OnError is called with the failures of relaying the connections,
+	// e.g. a *ConnectError, it may be the handler of WithErrorHandler. It
+	// must not block.
+	OnError netstackgo.ErrorHandler
+}
+
+// ConnectError is returned when the proxy server rejects a CONNECT request.
+type ConnectError struct {
+	Address    string
+	StatusCode int
+	Status     string
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("http proxy: CONNECT %s: %s", e.Address, e.Status)
+}
+
+// HTTP is a ConnHandler tunneling TCP connections through an HTTP/1.1
+// CONNECT proxy. UDP sessions are not supported and closed immediately.
+type HTTP struct {
+	cfg    HTTPConfig
+	dialer *dialer
+}
+
+func NewHTTP(cfg HTTPConfig) *HTTP {
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = defaultTCPIdleTimeout
+	}
+	return &HTTP{
+		cfg:    cfg,
+		dialer: newDialer(cfg.Interface, cfg.Timeout, cfg.Resolver),
+	}
+}
+
+func (h *HTTP) HandleTCPConn(connTuple netstackgo.ConnTuple, conn net.Conn) {
+	target, err := h.DialContext(context.Background(), targetAddr(connTuple))
+	if err != nil {
+		reportError(h.cfg.OnError, connTuple, err)
+		return
+	}
+	relay.Relay(conn, target, relay.Options{IdleTimeout: h.cfg.IdleTimeout})
+}
+
+func (h *HTTP) HandleUDPConn(netstackgo.ConnTuple, net.PacketConn) {}
+
+// DialContext connects to address (host:port) through the HTTP proxy.
+func (h *HTTP) DialContext(ctx context.Context, address string) (net.Conn, error) {
+	conn, err := h.dialer.DialContext(ctx, "tcp", h.cfg.Server)
+	if err != nil {
+		return nil, fmt.Errorf("http proxy: dial server: %w", err)
+	}
+	if h.cfg.TLS {
+		conn, err = h.handshakeTLS(ctx, conn)
+		if err != nil {
+			return nil, err
+		}
+	}
+	tunnel, err := h.connect(conn, address)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tunnel, nil
+}
+
+func (h *HTTP) handshakeTLS(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	config := &tls.Config{}
+	if h.cfg.TLSConfig != nil {
+		config = h.cfg.TLSConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName, _, _ = net.SplitHostPort(h.cfg.Server)
+	}
+	ctx, cancel := context.WithTimeout(ctx, h.dialer.timeout)
+	defer cancel()
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("http proxy: tls handshake: %w", err)
+	}
+	return tlsConn, nil
+}
+
+func (h *HTTP) connect(conn net.Conn, address string) (net.Conn, error) {
+	_ = conn.SetDeadline(time.Now().Add(h.dialer.timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Host: address},
+		Host:   address,
+		Header: h.cfg.Header.Clone(),
+	}
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	if h.cfg.Username != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(h.cfg.Username + ":" + h.cfg.Password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("http proxy: write request: %w", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, fmt.Errorf("http proxy: read response: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &ConnectError{
+			Address:    address,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+		}
+	}
+	// the server may speak first right after the response
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn reads the bytes left in the buffered reader first.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	if c.r.Buffered() > 0 {
+		return c.r.Read(b)
+	}
+	return c.Conn.Read(b)
+}
@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/josexy/netstackgo"
+	"github.com/josexy/netstackgo/relay"
+)
+
+func newHTTPProxy(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				user, pass, ok := parseProxyAuth(req.Header.Get("Proxy-Authorization"))
+				if !ok || user != "user" || pass != "pass" || req.Header.Get("X-Test") != "1" {
+					io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+					return
+				}
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				// the server greeting is sent along with the response
+				io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\nhi")
+				relay.Relay(conn, target, relay.Options{})
+			}()
+		}
+	}()
+	return ln
+}
+
+func parseProxyAuth(auth string) (string, string, bool) {
+	req := &http.Request{Header: http.Header{"Authorization": {auth}}}
+	return req.BasicAuth()
+}
+
+func TestHTTPConnect(t *testing.T) {
+	echo, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		conn, err := echo.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(conn, conn)
+		conn.Close()
+	}()
+
+	server := newHTTPProxy(t)
+	handler := NewHTTP(HTTPConfig{
+		Server:    server.Addr().String(),
+		Username:  "user",
+		Password:  "pass",
+		Header:    http.Header{"X-Test": {"1"}},
+		Interface: "lo",
+	})
+	conn, err := handler.DialContext(context.Background(), echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err = io.WriteString(conn, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 7)
+	if _, err = io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hihello" {
+		t.Fatalf("unexpected data %q", buf)
+	}
+
+	handler = NewHTTP(HTTPConfig{Server: server.Addr().String(), Interface: "lo"})
+	_, err = handler.DialContext(context.Background(), echo.Addr().String())
+	var connectErr *ConnectError
+	if !errors.As(err, &connectErr) || connectErr.StatusCode != http.StatusProxyAuthRequired {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestHTTPReportsErrors(t *testing.T) {
+	// nothing listens on the target any more, the proxy answers 502
+	closed, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := netip.MustParseAddrPort(closed.Addr().String())
+	closed.Close()
+
+	server := newHTTPProxy(t)
+	for _, tt := range []struct {
+		name   string
+		cfg    HTTPConfig
+		status int
+	}{
+		{
+			name:   "unauthorized",
+			cfg:    HTTPConfig{Username: "user", Password: "wrong"},
+			status: http.StatusProxyAuthRequired,
+		},
+		{
+			name:   "unreachable",
+			cfg:    HTTPConfig{Username: "user", Password: "pass", Header: http.Header{"X-Test": {"1"}}},
+			status: http.StatusBadGateway,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := make(errorRecorder, 1)
+			tt.cfg.Server = server.Addr().String()
+			tt.cfg.Interface = "lo"
+			tt.cfg.OnError = errs.handle
+			client, conn := net.Pipe()
+			defer client.Close()
+			go NewHTTP(tt.cfg).HandleTCPConn(netstackgo.ConnTuple{DstAddr: target}, conn)
+
+			var connectErr *ConnectError
+			if err := errs.wait(t); !errors.As(err, &connectErr) || connectErr.StatusCode != tt.status {
+				t.Fatalf("unexpected error %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,386 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/josexy/netstackgo"
+	"github.com/josexy/netstackgo/relay"
+)
+
+const (
+	socks5Version = 0x05
+
+	socks5AuthNone         = 0x00
+	socks5AuthPassword     = 0x02
+	socks5AuthNoAcceptable = 0xff
+
+	socks5CmdConnect      = 0x01
+	socks5CmdUDPAssociate = 0x03
+
+	socks5AtypIPv4   = 0x01
+	socks5AtypDomain = 0x03
+	socks5AtypIPv6   = 0x04
+
+	// maxSOCKS5AddrLen is the maximum size of ATYP, ADDR and PORT.
+	maxSOCKS5AddrLen = 1 + 1 + 255 + 2
+
+	// maxUDPPacketSize is the maximum size of a UDP datagram.
+	maxUDPPacketSize = 65535
+
+	// defaultUDPIdleTimeout is the time a UDP association stays open
+	// without any datagram in either direction.
+	defaultUDPIdleTimeout = 60 * time.Second
+)
+
+var socks5Replies = map[byte]string{
+	0x01: "general SOCKS server failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// SOCKS5Config is the configuration of the SOCKS5 outbound handler.
+type SOCKS5Config struct {
+	// Server is the address (host:port) of the SOCKS5 server.
+	Server string
+	// Username and Password enable the username/password authentication
+	// (RFC 1929) if Username is not empty.
+	Username string
+	Password string
+	// Interface is the outbound interface to reach the server, the
+	// default route interface is used if empty.
+	Interface string
+	// Timeout is the timeout of connecting and handshaking with the
+	// server.
+	Timeout time.Duration
+	// Resolver resolves the host of Server if it's not an ip address, a
+	// literal ip address is required if nil. It must not send the queries
+	// through the tun device, e.g. its Dial should be bound to Interface.
+	Resolver *net.Resolver
+	// IdleTimeout closes a relayed TCP connection, half-closed or not, if
+	// no data is relayed in either direction for this duration. It
+	// defaults to 5 minutes.
+	IdleTimeout time.Duration
+	// OnError is called with the failures of relaying the connections,
+	// e.g. a rejected request, it may be the handler of WithErrorHandler.
+	// It must not block.
+	OnError netstackgo.ErrorHandler
+}
+
+// ReplyError is returned when the SOCKS5 server rejects a request.
+type ReplyError struct {
+	Address string
+	Reply   byte
+}
+
+func (e *ReplyError) Error() string {
+	if msg, ok := socks5Replies[e.Reply]; ok {
+		return fmt.Sprintf("socks5: %s: %s", e.Address, msg)
+	}
+	return fmt.Sprintf("socks5: %s: unknown reply %d", e.Address, e.Reply)
+}
+
+// SOCKS5 is a ConnHandler relaying TCP connections with SOCKS5 CONNECT and
+// UDP sessions with SOCKS5 UDP ASSOCIATE (RFC 1928).
+type SOCKS5 struct {
+	cfg    SOCKS5Config
+	dialer *dialer
+}
+
+func NewSOCKS5(cfg SOCKS5Config) *SOCKS5 {
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = defaultTCPIdleTimeout
+	}
+	return &SOCKS5{
+		cfg:    cfg,
+		dialer: newDialer(cfg.Interface, cfg.Timeout, cfg.Resolver),
+	}
+}
+
+func (s *SOCKS5) HandleTCPConn(connTuple netstackgo.ConnTuple, conn net.Conn) {
+	target, err := s.DialContext(context.Background(), targetAddr(connTuple))
+	if err != nil {
+		reportError(s.cfg.OnError, connTuple, err)
+		return
+	}
+	relay.Relay(conn, target, relay.Options{IdleTimeout: s.cfg.IdleTimeout})
+}
+
+// DialContext connects to address (host:port) through the SOCKS5 server.
+func (s *SOCKS5) DialContext(ctx context.Context, address string) (net.Conn, error) {
+	conn, err := s.dialer.DialContext(ctx, "tcp", s.cfg.Server)
+	if err != nil {
+		return nil, fmt.Errorf("socks5: dial server: %w", err)
+	}
+	if _, err = s.handshake(conn, socks5CmdConnect, address); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (s *SOCKS5) HandleUDPConn(connTuple netstackgo.ConnTuple, conn net.PacketConn) {
+	ctrl, err := s.dialer.DialContext(context.Background(), "tcp", s.cfg.Server)
+	if err != nil {
+		reportError(s.cfg.OnError, connTuple, fmt.Errorf("socks5: dial server: %w", err))
+		return
+	}
+	defer ctrl.Close()
+	// the client address and port of the association are unknown yet
+	relayAddr, err := s.handshake(ctrl, socks5CmdUDPAssociate, "0.0.0.0:0")
+	if err != nil {
+		reportError(s.cfg.OnError, connTuple, err)
+		return
+	}
+	// the server may reply with an unspecified address, it means the
+	// address of the server itself
+	if relayAddr.Addr().IsUnspecified() {
+		serverAddr, err := netip.ParseAddrPort(ctrl.RemoteAddr().String())
+		if err != nil {
+			reportError(s.cfg.OnError, connTuple, err)
+			return
+		}
+		relayAddr = netip.AddrPortFrom(serverAddr.Addr().Unmap(), relayAddr.Port())
+	}
+
+	pc, err := s.dialer.ListenPacket(context.Background(), "udp", "")
+	if err != nil {
+		reportError(s.cfg.OnError, connTuple, fmt.Errorf("socks5: listen udp: %w", err))
+		return
+	}
+	defer pc.Close()
+
+	// the association terminates when the control connection closes
+	go func() {
+		_, _ = io.Copy(io.Discard, ctrl)
+		pc.Close()
+		conn.Close()
+	}()
+
+	relayUDPAddr := net.UDPAddrFromAddrPort(relayAddr)
+	header, err := appendSOCKS5Addr([]byte{0, 0, 0}, targetAddr(connTuple))
+	if err != nil {
+		reportError(s.cfg.OnError, connTuple, err)
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, maxUDPPacketSize)
+		for {
+			_ = pc.SetReadDeadline(time.Now().Add(defaultUDPIdleTimeout))
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			// drop the datagrams not sent by the relay of the association
+			if udpAddr, ok := from.(*net.UDPAddr); !ok || !sameAddrPort(udpAddr.AddrPort(), relayAddr) {
+				continue
+			}
+			payload, err := parseSOCKS5UDP(buf[:n])
+			if err != nil {
+				continue
+			}
+			if _, err = conn.WriteTo(payload, net.UDPAddrFromAddrPort(connTuple.SrcAddr)); err != nil {
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, maxUDPPacketSize)
+	packet := make([]byte, 0, len(header)+maxUDPPacketSize)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(defaultUDPIdleTimeout))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		packet = append(append(packet[:0], header...), buf[:n]...)
+		if _, err = pc.WriteTo(packet, relayUDPAddr); err != nil {
+			break
+		}
+	}
+	pc.Close()
+	<-done
+}
+
+func sameAddrPort(a, b netip.AddrPort) bool {
+	return a.Addr().Unmap() == b.Addr().Unmap() && a.Port() == b.Port()
+}
+
+// handshake authenticates with the server and sends the request of cmd, it
+// returns the bound address in the reply.
+func (s *SOCKS5) handshake(conn net.Conn, cmd byte, address string) (netip.AddrPort, error) {
+	timeout := s.cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultDialTimeout
+	}
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	method := byte(socks5AuthNone)
+	if s.cfg.Username != "" {
+		method = socks5AuthPassword
+	}
+	if _, err := conn.Write([]byte{socks5Version, 1, method}); err != nil {
+		return netip.AddrPort{}, err
+	}
+	var buf [maxSOCKS5AddrLen + 3]byte
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return netip.AddrPort{}, err
+	}
+	if buf[0] != socks5Version {
+		return netip.AddrPort{}, fmt.Errorf("socks5: unexpected version %d", buf[0])
+	}
+	switch buf[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if err := s.authenticate(conn); err != nil {
+			return netip.AddrPort{}, err
+		}
+	case socks5AuthNoAcceptable:
+		return netip.AddrPort{}, errors.New("socks5: no acceptable authentication method")
+	default:
+		return netip.AddrPort{}, fmt.Errorf("socks5: unsupported authentication method %d", buf[1])
+	}
+
+	req, err := appendSOCKS5Addr([]byte{socks5Version, cmd, 0}, address)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	if _, err = conn.Write(req); err != nil {
+		return netip.AddrPort{}, err
+	}
+	// VER, REP, RSV
+	if _, err = io.ReadFull(conn, buf[:3]); err != nil {
+		return netip.AddrPort{}, err
+	}
+	if buf[1] != 0x00 {
+		return netip.AddrPort{}, &ReplyError{Address: address, Reply: buf[1]}
+	}
+	bound, err := readSOCKS5Addr(conn)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	addr, _ := netip.ParseAddrPort(bound)
+	return addr, nil
+}
+
+// authenticate performs the username/password authentication (RFC 1929).
+func (s *SOCKS5) authenticate(conn net.Conn) error {
+	if len(s.cfg.Username) > 255 || len(s.cfg.Password) > 255 {
+		return errors.New("socks5: username or password is too long")
+	}
+	req := []byte{0x01, byte(len(s.cfg.Username))}
+	req = append(req, s.cfg.Username...)
+	req = append(req, byte(len(s.cfg.Password)))
+	req = append(req, s.cfg.Password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	var resp [2]byte
+	if _, err := io.ReadFull(conn, resp[:]); err != nil {
+		return err
+	}
+	if resp[1] != 0x00 {
+		return errors.New("socks5: authentication failed")
+	}
+	return nil
+}
+
+// targetAddr returns the destination of the connection, preferring the
+// domain name because a fake ip address can't be reached by the server.
+func targetAddr(connTuple netstackgo.ConnTuple) string {
+	if host := connTuple.Hostname(); host != "" {
+		return net.JoinHostPort(host, strconv.Itoa(int(connTuple.DstAddr.Port())))
+	}
+	return connTuple.DstAddr.String()
+}
+
+func appendSOCKS5Addr(b []byte, address string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if ip = ip.Unmap(); ip.Is4() {
+			b = append(b, socks5AtypIPv4)
+		} else {
+			b = append(b, socks5AtypIPv6)
+		}
+		b = append(b, ip.AsSlice()...)
+	} else {
+		if len(host) > 255 {
+			return nil, errors.New("socks5: domain name is too long")
+		}
+		b = append(b, socks5AtypDomain, byte(len(host)))
+		b = append(b, host...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port)), nil
+}
+
+func readSOCKS5Addr(r io.Reader) (string, error) {
+	var buf [maxSOCKS5AddrLen]byte
+	if _, err := io.ReadFull(r, buf[:1]); err != nil {
+		return "", err
+	}
+	var host string
+	switch buf[0] {
+	case socks5AtypIPv4, socks5AtypIPv6:
+		n := net.IPv4len
+		if buf[0] == socks5AtypIPv6 {
+			n = net.IPv6len
+		}
+		if _, err := io.ReadFull(r, buf[:n]); err != nil {
+			return "", err
+		}
+		ip, _ := netip.AddrFromSlice(buf[:n])
+		host = ip.String()
+	case socks5AtypDomain:
+		if _, err := io.ReadFull(r, buf[:1]); err != nil {
+			return "", err
+		}
+		n := int(buf[0])
+		if _, err := io.ReadFull(r, buf[:n]); err != nil {
+			return "", err
+		}
+		host = string(buf[:n])
+	default:
+		return "", fmt.Errorf("socks5: unknown address type %d", buf[0])
+	}
+	if _, err := io.ReadFull(r, buf[:2]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf[:2])))), nil
+}
+
+// parseSOCKS5UDP strips the header of a UDP ASSOCIATE datagram.
+func parseSOCKS5UDP(b []byte) ([]byte, error) {
+	// RSV, FRAG
+	if len(b) < 4 || b[2] != 0x00 {
+		return nil, errors.New("socks5: fragmented or malformed datagram")
+	}
+	r := bytes.NewReader(b[3:])
+	if _, err := readSOCKS5Addr(r); err != nil {
+		return nil, err
+	}
+	return b[len(b)-r.Len():], nil
+}
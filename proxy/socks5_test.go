@@ -0,0 +1,411 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/josexy/netstackgo"
+	"github.com/josexy/netstackgo/relay"
+	"github.com/josexy/netstackgo/tun/core/adapter"
+)
+
+// socks5Server is a minimal SOCKS5 server with username/password
+// authentication, CONNECT and UDP ASSOCIATE.
+type socks5Server struct {
+	t        *testing.T
+	ln       net.Listener
+	username string
+	password string
+	// spoof sends a forged reply to the client of each association
+	// from a socket other than the relay
+	spoof net.PacketConn
+}
+
+func newSOCKS5Server(t *testing.T, username, password string) *socks5Server {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &socks5Server{t: t, ln: ln, username: username, password: password}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *socks5Server) serve(conn net.Conn) {
+	defer conn.Close()
+	buf := make([]byte, 512)
+	// VER, NMETHODS, METHODS
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	nmethods := buf[1]
+	if _, err := io.ReadFull(conn, buf[:nmethods]); err != nil {
+		return
+	}
+	if !bytes.Contains(buf[:nmethods], []byte{socks5AuthPassword}) {
+		conn.Write([]byte{socks5Version, socks5AuthNoAcceptable})
+		return
+	}
+	conn.Write([]byte{socks5Version, socks5AuthPassword})
+	// VER, ULEN, UNAME, PLEN, PASSWD
+	io.ReadFull(conn, buf[:2])
+	ulen := buf[1]
+	io.ReadFull(conn, buf[:ulen])
+	username := string(buf[:ulen])
+	io.ReadFull(conn, buf[:1])
+	plen := buf[0]
+	io.ReadFull(conn, buf[:plen])
+	password := string(buf[:plen])
+	if username != s.username || password != s.password {
+		conn.Write([]byte{0x01, 0x01})
+		return
+	}
+	conn.Write([]byte{0x01, 0x00})
+
+	// VER, CMD, RSV, DST
+	if _, err := io.ReadFull(conn, buf[:3]); err != nil {
+		return
+	}
+	cmd := buf[1]
+	dst, err := readSOCKS5Addr(conn)
+	if err != nil {
+		return
+	}
+	switch cmd {
+	case socks5CmdConnect:
+		target, err := net.Dial("tcp", dst)
+		if err != nil {
+			conn.Write([]byte{socks5Version, 0x05, 0, socks5AtypIPv4, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		reply, _ := appendSOCKS5Addr([]byte{socks5Version, 0, 0}, target.LocalAddr().String())
+		conn.Write(reply)
+		relay.Relay(conn, target, relay.Options{})
+	case socks5CmdUDPAssociate:
+		pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		if err != nil {
+			return
+		}
+		defer pc.Close()
+		// reply an unspecified address to test the fallback
+		port := pc.LocalAddr().(*net.UDPAddr).Port
+		reply, _ := appendSOCKS5Addr([]byte{socks5Version, 0, 0}, net.JoinHostPort("0.0.0.0", strconv.Itoa(port)))
+		conn.Write(reply)
+		go s.serveUDP(pc)
+		io.Copy(io.Discard, conn)
+	}
+}
+
+func (s *socks5Server) serveUDP(pc net.PacketConn) {
+	buf := make([]byte, maxUDPPacketSize)
+	var client net.Addr
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		// the first datagram comes from the client
+		if client == nil {
+			client = from
+			if s.spoof != nil {
+				packet, _ := appendSOCKS5Addr([]byte{0, 0, 0}, "127.0.0.1:53")
+				s.spoof.WriteTo(append(packet, "spoofed"...), client)
+			}
+		}
+		if from.String() == client.String() {
+			// forward the payload to the target
+			r := bytes.NewReader(buf[3:n])
+			dst, err := readSOCKS5Addr(r)
+			if err != nil {
+				continue
+			}
+			dstAddr, _ := net.ResolveUDPAddr("udp", dst)
+			pc.WriteTo(buf[n-r.Len():n], dstAddr)
+			continue
+		}
+		// wrap the response from the target
+		packet, _ := appendSOCKS5Addr([]byte{0, 0, 0}, from.String())
+		pc.WriteTo(append(packet, buf[:n]...), client)
+	}
+}
+
+func TestSOCKS5TCP(t *testing.T) {
+	echo, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		conn, err := echo.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(conn, conn)
+		conn.Close()
+	}()
+
+	server := newSOCKS5Server(t, "user", "pass")
+	handler := NewSOCKS5(SOCKS5Config{
+		Server:    server.ln.Addr().String(),
+		Username:  "user",
+		Password:  "pass",
+		Interface: "lo",
+	})
+
+	client, conn := net.Pipe()
+	go handler.HandleTCPConn(netstackgo.ConnTuple{
+		DstAddr: netip.MustParseAddrPort(echo.Addr().String()),
+	}, conn)
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err = client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err = io.ReadFull(client, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Fatalf("unexpected echo %q", buf)
+	}
+	client.Close()
+
+	handler = NewSOCKS5(SOCKS5Config{Server: server.ln.Addr().String(), Interface: "lo"})
+	if _, err = handler.DialContext(context.Background(), echo.Addr().String()); err == nil {
+		t.Fatal("unauthenticated connect succeeded")
+	}
+}
+
+func TestSOCKS5UDP(t *testing.T) {
+	echo, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, from, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteTo(buf[:n], from)
+		}
+	}()
+
+	// conn stands in for the UDP session of the netstack
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := net.DialUDP("udp4", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	server := newSOCKS5Server(t, "user", "pass")
+	// the forged reply arrives before the echo and must be dropped
+	server.spoof, err = net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.spoof.Close()
+	handler := NewSOCKS5(SOCKS5Config{
+		Server:    server.ln.Addr().String(),
+		Username:  "user",
+		Password:  "pass",
+		Interface: "lo",
+	})
+	go handler.HandleUDPConn(netstackgo.ConnTuple{
+		SrcAddr: netip.MustParseAddrPort(client.LocalAddr().String()),
+		DstAddr: netip.MustParseAddrPort(echo.LocalAddr().String()),
+	}, conn)
+	defer conn.Close()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err = client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "ping" {
+		t.Fatalf("unexpected echo %q", buf[:n])
+	}
+}
+
+func TestSOCKS5ServerHostName(t *testing.T) {
+	server := newSOCKS5Server(t, "user", "pass")
+	_, port, _ := net.SplitHostPort(server.ln.Addr().String())
+	address := net.JoinHostPort("localhost", port)
+
+	// a host name is never resolved with the system resolver
+	handler := NewSOCKS5(SOCKS5Config{Server: address, Username: "user", Password: "pass", Interface: "lo"})
+	if _, err := handler.DialContext(context.Background(), "127.0.0.1:1"); err == nil {
+		t.Fatal("expected an error without a resolver")
+	}
+
+	handler = NewSOCKS5(SOCKS5Config{
+		Server:    address,
+		Username:  "user",
+		Password:  "pass",
+		Interface: "lo",
+		Resolver:  &net.Resolver{PreferGo: true},
+	})
+	echo, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		conn, err := echo.Accept()
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}()
+	conn, err := handler.DialContext(context.Background(), echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestSOCKS5TCPIdleTimeout(t *testing.T) {
+	target, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		// the target half-closes and then stays silent
+		conn, err := target.Accept()
+		if err != nil {
+			return
+		}
+		conn.(*net.TCPConn).CloseWrite()
+		io.Copy(io.Discard, conn)
+	}()
+
+	server := newSOCKS5Server(t, "user", "pass")
+	handler := NewSOCKS5(SOCKS5Config{
+		Server:      server.ln.Addr().String(),
+		Username:    "user",
+		Password:    "pass",
+		Interface:   "lo",
+		IdleTimeout: 100 * time.Millisecond,
+	})
+
+	// a TCP pair keeps the relay half-closed, a pipe can't be half-closed
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.HandleTCPConn(netstackgo.ConnTuple{
+			DstAddr: netip.MustParseAddrPort(target.Addr().String()),
+		}, conn)
+	}()
+	defer client.Close()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the idle relay was not closed")
+	}
+}
+
+// errorRecorder records the errors reported to its handler.
+type errorRecorder chan error
+
+func (r errorRecorder) handle(kind adapter.ErrorKind, _ netstackgo.ConnTuple, err error) {
+	if kind == adapter.ErrorProxy {
+		r <- err
+	}
+}
+
+func (r errorRecorder) wait(t *testing.T) error {
+	t.Helper()
+	select {
+	case err := <-r:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("no error was reported")
+		return nil
+	}
+}
+
+func TestSOCKS5ReportsErrors(t *testing.T) {
+	// nothing listens on the target any more, the server refuses CONNECT
+	closed, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := closed.Addr().String()
+	closed.Close()
+
+	server := newSOCKS5Server(t, "user", "pass")
+	errs := make(errorRecorder, 1)
+	handler := NewSOCKS5(SOCKS5Config{
+		Server:    server.ln.Addr().String(),
+		Username:  "user",
+		Password:  "pass",
+		Interface: "lo",
+		OnError:   errs.handle,
+	})
+	client, conn := net.Pipe()
+	defer client.Close()
+	go handler.HandleTCPConn(netstackgo.ConnTuple{DstAddr: netip.MustParseAddrPort(target)}, conn)
+	var replyErr *ReplyError
+	if err = errs.wait(t); !errors.As(err, &replyErr) || replyErr.Reply != 0x05 {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// the UDP ASSOCIATE is rejected by the authentication
+	handler = NewSOCKS5(SOCKS5Config{
+		Server:    server.ln.Addr().String(),
+		Username:  "user",
+		Password:  "wrong",
+		Interface: "lo",
+		OnError:   errs.handle,
+	})
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	go handler.HandleUDPConn(netstackgo.ConnTuple{DstAddr: netip.MustParseAddrPort("127.0.0.1:53")}, pc)
+	if err = errs.wait(t); err == nil || err.Error() != "socks5: authentication failed" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+// Package relay copies data between two connections in both directions,
+// keeping the TCP half-close semantics.
+package relay
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// bufferSize is the size of the pooled copy buffers.
+const bufferSize = 32 << 10
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, bufferSize)
+		return &b
+	},
+}
+
+// CloseReason tells why a relay finished.
+type CloseReason string
+
+const (
+	// ReasonEOF means both sides finished sending normally.
+	ReasonEOF CloseReason = "eof"
+	// ReasonError means reading or writing either side failed.
+	ReasonError CloseReason = "error"
+	// ReasonIdleTimeout means no data was relayed in either direction
+	// within the idle timeout.
+	ReasonIdleTimeout CloseReason = "idle_timeout"
+	// ReasonTimeout means the relay lasted longer than the total timeout.
+	ReasonTimeout CloseReason = "timeout"
+)
+
+// Options configures a relay. The zero value disables both timeouts.
+type Options struct {
+	// IdleTimeout closes the connections if no data is relayed in
+	// either direction for this duration.
+	IdleTimeout time.Duration
+	// Timeout closes the connections after this duration.
+	Timeout time.Duration
+}
+
+// Result is the statistics of a finished relay.
+type Result struct {
+	// Up is the number of bytes copied from left to right.
+	Up int64
+	// Down is the number of bytes copied from right to left.
+	Down int64
+	// Duration is the lifetime of the relay.
+	Duration time.Duration
+	Reason   CloseReason
+	// Err is the first error encountered if Reason is ReasonError.
+	Err error
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+type relay struct {
+	left, right net.Conn
+	lastActive  atomic.Int64
+	reason      atomic.Pointer[CloseReason]
+	err         error
+	done        chan struct{}
+}
+
+// Relay copies data between left and right in both directions until both
+// directions finish, and then closes both connections. When one direction
+// reaches EOF, the write side of the destination is shut down with
+// CloseWrite if supported, so that the peer sees the half-close.
+func Relay(left, right net.Conn, opts Options) Result {
+	r := &relay{
+		left:  left,
+		right: right,
+		done:  make(chan struct{}),
+	}
+	start := time.Now()
+	r.lastActive.Store(start.UnixNano())
+	if opts.IdleTimeout > 0 || opts.Timeout > 0 {
+		go r.watch(start, opts)
+	}
+
+	var res Result
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		res.Down = r.copy(left, right)
+	}()
+	res.Up = r.copy(right, left)
+	wg.Wait()
+	eof := ReasonEOF
+	r.reason.CompareAndSwap(nil, &eof)
+	close(r.done)
+	left.Close()
+	right.Close()
+
+	res.Duration = time.Since(start)
+	res.Reason = *r.reason.Load()
+	if res.Reason == ReasonError {
+		res.Err = r.err
+	}
+	return res
+}
+
+// copy copies from src to dst until EOF or error.
+func (r *relay) copy(dst, src net.Conn) int64 {
+	bufp := bufferPool.Get().(*[]byte)
+	defer bufferPool.Put(bufp)
+	buf := *bufp
+
+	var written int64
+	for {
+		nr, rerr := src.Read(buf)
+		if nr > 0 {
+			r.lastActive.Store(time.Now().UnixNano())
+			nw, werr := dst.Write(buf[:nr])
+			written += int64(nw)
+			if werr == nil && nw != nr {
+				werr = io.ErrShortWrite
+			}
+			if werr != nil {
+				r.abort(ReasonError, werr)
+				return written
+			}
+		}
+		if rerr != nil {
+			if rerr == io.EOF {
+				r.closeWrite(dst)
+			} else {
+				r.abort(ReasonError, rerr)
+			}
+			return written
+		}
+	}
+}
+
+// closeWrite propagates EOF to dst, or closes dst if it can't be half-closed.
+func (r *relay) closeWrite(dst net.Conn) {
+	if cw, ok := dst.(closeWriter); ok {
+		if cw.CloseWrite() == nil {
+			return
+		}
+	}
+	dst.Close()
+}
+
+// abort records the reason if no reason was recorded yet, and unblocks both
+// directions.
+func (r *relay) abort(reason CloseReason, err error) {
+	// the error caused by closing the connections ourselves is not the reason
+	if reason == ReasonError && r.reason.Load() != nil {
+		return
+	}
+	if errors.Is(err, net.ErrClosed) {
+		return
+	}
+	if !r.reason.CompareAndSwap(nil, &reason) {
+		return
+	}
+	r.err = err
+	now := time.Now()
+	r.left.SetDeadline(now)
+	r.right.SetDeadline(now)
+}
+
+func (r *relay) watch(start time.Time, opts Options) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case now := <-timer.C:
+			next := time.Duration(-1)
+			if opts.Timeout > 0 {
+				remain := opts.Timeout - now.Sub(start)
+				if remain <= 0 {
+					r.abort(ReasonTimeout, nil)
+					return
+				}
+				next = remain
+			}
+			if opts.IdleTimeout > 0 {
+				remain := opts.IdleTimeout - now.Sub(time.Unix(0, r.lastActive.Load()))
+				if remain <= 0 {
+					r.abort(ReasonIdleTimeout, nil)
+					return
+				}
+				if next < 0 || remain < next {
+					next = remain
+				}
+			}
+			timer.Reset(next)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package relay
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// tcpPair returns the two ends of a loopback TCP connection.
+func tcpPair(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.(*net.TCPConn), server.(*net.TCPConn)
+}
+
+func TestRelayHalfClose(t *testing.T) {
+	client, left := tcpPair(t)
+	right, server := tcpPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	done := make(chan Result)
+	go func() { done <- Relay(left, right, Options{IdleTimeout: 5 * time.Second}) }()
+
+	// the server answers only after the client finished sending
+	go func() {
+		req, _ := io.ReadAll(server)
+		server.Write(append([]byte("re:"), req...))
+		server.Close()
+	}()
+	client.Write([]byte("ping"))
+	client.CloseWrite()
+	resp, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != "re:ping" {
+		t.Fatalf("unexpected response %q", resp)
+	}
+
+	res := <-done
+	if res.Up != 4 || res.Down != 7 || res.Reason != ReasonEOF {
+		t.Fatalf("unexpected result %+v", res)
+	}
+}
+
+func TestRelayIdleTimeout(t *testing.T) {
+	client, left := tcpPair(t)
+	right, server := tcpPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	res := Relay(left, right, Options{IdleTimeout: 100 * time.Millisecond, Timeout: 5 * time.Second})
+	if res.Reason != ReasonIdleTimeout {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	if _, err := client.Read(make([]byte, 1)); err == nil {
+		t.Fatal("connection is still open")
+	}
+}
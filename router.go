@@ -0,0 +1,149 @@
+package netstackgo
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/josexy/netstackgo/sniff"
+)
+
+// Matcher reports whether a connection matches a Rule.
+type Matcher func(ConnTuple) bool
+
+// Rule routes the matched connections to Handler.
+type Rule struct {
+	Name    string
+	Match   Matcher
+	Handler ConnHandler
+}
+
+// Router is a ConnHandler dispatching connections to the handler of the
+// first matched rule, or to the fallback handler if no rule matches.
+type Router struct {
+	rules    []Rule
+	fallback ConnHandler
+}
+
+func NewRouter(fallback ConnHandler, rules ...Rule) *Router {
+	return &Router{rules: rules, fallback: fallback}
+}
+
+// Route returns the first rule matching connTuple, or false if none matches.
+func (r *Router) Route(connTuple ConnTuple) (Rule, bool) {
+	for _, rule := range r.rules {
+		if rule.Match == nil || rule.Match(connTuple) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+func (r *Router) HandleTCPConn(connTuple ConnTuple, conn net.Conn) {
+	if handler := r.handler(connTuple, conn); handler != nil {
+		handler.HandleTCPConn(connTuple, conn)
+	}
+}
+
+func (r *Router) HandleUDPConn(connTuple ConnTuple, conn net.PacketConn) {
+	if handler := r.handler(connTuple, conn); handler != nil {
+		handler.HandleUDPConn(connTuple, conn)
+	}
+}
+
+// handler returns the handler of connTuple, and records the matched rule
+// for the access log if conn is passed from the netstack.
+func (r *Router) handler(connTuple ConnTuple, conn any) ConnHandler {
+	rule, ok := r.Route(connTuple)
+	if !ok {
+		rule = Rule{Handler: r.fallback}
+	}
+	if recorder, ok := conn.(routeRecorder); ok && rule.Handler != nil {
+		recorder.setRoute(rule.Name, rule.Handler)
+	}
+	return rule.Handler
+}
+
+// MatchDomain matches the connections to any of the domains.
+func MatchDomain(domains ...string) Matcher {
+	domains = normalizeDomains(domains)
+	return func(t ConnTuple) bool {
+		return slices.Contains(domains, t.Hostname())
+	}
+}
+
+// MatchDomainSuffix matches the connections to any of the domains or
+// their subdomains.
+func MatchDomainSuffix(domains ...string) Matcher {
+	domains = normalizeDomains(domains)
+	return func(t ConnTuple) bool {
+		host := t.Hostname()
+		for _, domain := range domains {
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchDstPrefix matches the connections whose destination address
+// belongs to any of the prefixes.
+func MatchDstPrefix(prefixes ...netip.Prefix) Matcher {
+	return func(t ConnTuple) bool {
+		addr := t.DstAddr.Addr().Unmap()
+		for _, prefix := range prefixes {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchDstPort matches the connections to any of the ports.
+func MatchDstPort(ports ...uint16) Matcher {
+	return func(t ConnTuple) bool {
+		return slices.Contains(ports, t.DstAddr.Port())
+	}
+}
+
+// MatchALPN matches the TLS connections offering any of the protocols.
+func MatchALPN(protos ...string) Matcher {
+	return func(t ConnTuple) bool {
+		for _, proto := range t.ALPNProtocols() {
+			if slices.Contains(protos, proto) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchProtocol matches the connections labeled as any of the protocols.
+func MatchProtocol(protos ...sniff.Protocol) Matcher {
+	return func(t ConnTuple) bool {
+		return slices.Contains(protos, t.Protocol)
+	}
+}
+
+// MatchAll matches the connections matched by all of the matchers.
+func MatchAll(matchers ...Matcher) Matcher {
+	return func(t ConnTuple) bool {
+		for _, match := range matchers {
+			if !match(t) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func normalizeDomains(domains []string) []string {
+	normalized := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		normalized = append(normalized, strings.ToLower(strings.Trim(domain, ".")))
+	}
+	return normalized
+}
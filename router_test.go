@@ -0,0 +1,147 @@
+package netstackgo
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/josexy/netstackgo/sniff"
+)
+
+type recordingHandler struct {
+	name  string
+	calls *[]string
+}
+
+func (h recordingHandler) HandleTCPConn(ConnTuple, net.Conn) {
+	*h.calls = append(*h.calls, h.name)
+}
+
+func (h recordingHandler) HandleUDPConn(ConnTuple, net.PacketConn) {
+	*h.calls = append(*h.calls, h.name)
+}
+
+func TestRouterFirstMatch(t *testing.T) {
+	var calls []string
+	router := NewRouter(recordingHandler{"fallback", &calls},
+		Rule{Name: "dns", Match: MatchDstPort(53), Handler: recordingHandler{"dns", &calls}},
+		Rule{Name: "udp53", Match: MatchDstPort(53, 5353), Handler: recordingHandler{"udp53", &calls}},
+		Rule{Name: "any", Handler: recordingHandler{"any", &calls}},
+	)
+	dns := ConnTuple{DstAddr: netip.MustParseAddrPort("1.1.1.1:53")}
+	if rule, ok := router.Route(dns); !ok || rule.Name != "dns" {
+		t.Fatalf("Route = %q, %v, want the first matched rule", rule.Name, ok)
+	}
+	// a rule without matcher matches everything
+	if rule, _ := router.Route(ConnTuple{DstAddr: netip.MustParseAddrPort("1.1.1.1:443")}); rule.Name != "any" {
+		t.Fatalf("Route = %q, want any", rule.Name)
+	}
+
+	router.HandleTCPConn(dns, nil)
+	router.HandleUDPConn(ConnTuple{DstAddr: netip.MustParseAddrPort("1.1.1.1:5353")}, nil)
+	if len(calls) != 2 || calls[0] != "dns" || calls[1] != "udp53" {
+		t.Fatalf("handlers called %v", calls)
+	}
+}
+
+func TestRouterFallback(t *testing.T) {
+	var calls []string
+	router := NewRouter(recordingHandler{"fallback", &calls},
+		Rule{Name: "dns", Match: MatchDstPort(53), Handler: recordingHandler{"dns", &calls}},
+	)
+	connTuple := ConnTuple{DstAddr: netip.MustParseAddrPort("1.1.1.1:443")}
+	if _, ok := router.Route(connTuple); ok {
+		t.Fatal("Route matched a rule")
+	}
+	router.HandleTCPConn(connTuple, nil)
+	if len(calls) != 1 || calls[0] != "fallback" {
+		t.Fatalf("handlers called %v", calls)
+	}
+
+	// no handler at all is not an error
+	NewRouter(nil).HandleTCPConn(connTuple, nil)
+}
+
+func TestRouterRecordsRoute(t *testing.T) {
+	var calls []string
+	router := NewRouter(recordingHandler{"fallback", &calls},
+		Rule{Name: "dns", Match: MatchDstPort(53), Handler: recordingHandler{"dns", &calls}},
+	)
+	conns := newConnTable()
+	for _, tt := range []struct {
+		dst  string
+		rule string
+	}{
+		{dst: "1.1.1.1:53", rule: "dns"},
+		{dst: "1.1.1.1:443", rule: ""},
+	} {
+		connTuple := ConnTuple{DstAddr: netip.MustParseAddrPort(tt.dst)}
+		tracked, conn := conns.addTCP(connTuple, nil)
+		router.HandleTCPConn(connTuple, conn)
+		route := tracked.getRoute()
+		if route.rule != tt.rule || route.handler != "netstackgo.recordingHandler" {
+			t.Errorf("%s: route = %+v, want rule %q", tt.dst, route, tt.rule)
+		}
+	}
+}
+
+func TestMatchers(t *testing.T) {
+	connTuple := ConnTuple{
+		SrcAddr:  netip.MustParseAddrPort("198.18.0.1:50000"),
+		DstAddr:  netip.MustParseAddrPort("[::ffff:10.1.2.3]:443"),
+		Domain:   "cdn.example.com",
+		Host:     "api.example.com",
+		ALPN:     "h2,http/1.1",
+		Protocol: sniff.ProtocolTLS,
+	}
+
+	for _, tt := range []struct {
+		name  string
+		match Matcher
+		want  bool
+	}{
+		{"domain", MatchDomain("API.example.com."), true},
+		{"domain uses host first", MatchDomain("cdn.example.com"), false},
+		{"domain suffix", MatchDomainSuffix("example.com"), true},
+		{"domain suffix itself", MatchDomainSuffix("api.example.com"), true},
+		{"domain suffix label boundary", MatchDomainSuffix("ple.com"), false},
+		{"dst prefix unmaps", MatchDstPrefix(netip.MustParsePrefix("10.0.0.0/8")), true},
+		{"dst prefix miss", MatchDstPrefix(netip.MustParsePrefix("192.168.0.0/16")), false},
+		{"dst port", MatchDstPort(80, 443), true},
+		{"dst port miss", MatchDstPort(80), false},
+		{"alpn", MatchALPN("http/1.1"), true},
+		{"alpn miss", MatchALPN("h3"), false},
+		{"protocol", MatchProtocol(sniff.ProtocolSSH, sniff.ProtocolTLS), true},
+		{"protocol miss", MatchProtocol(sniff.ProtocolSSH), false},
+		{"all", MatchAll(MatchDstPort(443), MatchALPN("h2")), true},
+		{"all miss", MatchAll(MatchDstPort(443), MatchALPN("h3")), false},
+	} {
+		if got := tt.match(connTuple); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Domain is matched if nothing is sniffed, and no ALPN matches nothing
+	connTuple.Host, connTuple.ALPN = "", ""
+	if !MatchDomain("cdn.example.com")(connTuple) {
+		t.Error("domain doesn't fall back to Domain")
+	}
+	if MatchALPN("")(connTuple) {
+		t.Error("empty ALPN matched")
+	}
+}
+
+func TestConnTupleComparable(t *testing.T) {
+	a := ConnTuple{DstAddr: netip.MustParseAddrPort("1.1.1.1:443"), ALPN: "h2,http/1.1"}
+	b := a
+	seen := map[ConnTuple]bool{a: true}
+	if a != b || !seen[b] {
+		t.Fatal("equal tuples differ")
+	}
+	if got := a.ALPNProtocols(); len(got) != 2 || got[0] != "h2" || got[1] != "http/1.1" {
+		t.Fatalf("ALPNProtocols = %q", got)
+	}
+	if got := (&ConnTuple{}).ALPNProtocols(); got != nil {
+		t.Fatalf("ALPNProtocols = %q, want nil", got)
+	}
+}
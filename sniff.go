@@ -0,0 +1,159 @@
+package netstackgo
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/josexy/netstackgo/sniff"
+	"github.com/josexy/netstackgo/tun/core/adapter"
+)
+
+// maxSniffSize is large enough for a ClientHello in a full TLS record.
+const maxSniffSize = 16<<10 + 5
+
+// ProtocolConn is implemented by the TCP connections passed to ConnHandler
+// when sniffing is enabled. Unlike ConnTuple.Protocol, which is labeled from
+// the client bytes only, Protocol is refined by the first bytes written back
+// to the client, so that the protocols in which the server speaks first are
+// labeled too.
+type ProtocolConn interface {
+	Protocol() sniff.Protocol
+}
+
+// sniffConn replays the bytes peeked by the sniffer before reading from
+// the underlying connection.
+type sniffConn struct {
+	adapter.TCPConn
+	peeked     []byte
+	err        error
+	protocol   atomic.Value // sniff.Protocol
+	serverSeen atomic.Bool
+}
+
+func (c *sniffConn) Protocol() sniff.Protocol {
+	return c.protocol.Load().(sniff.Protocol)
+}
+
+func (c *sniffConn) Write(b []byte) (int, error) {
+	if len(b) > 0 && !c.serverSeen.Swap(true) && c.Protocol() == sniff.ProtocolUnknown {
+		c.protocol.Store(sniff.ClassifyServer(b))
+	}
+	return c.TCPConn.Write(b)
+}
+
+func (c *sniffConn) Read(b []byte) (int, error) {
+	if len(c.peeked) > 0 {
+		n := copy(b, c.peeked)
+		c.peeked = c.peeked[n:]
+		return n, nil
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.TCPConn.Read(b)
+}
+
+// sniffTCPConn reads the first client bytes of conn until both the sniffer
+// and the classifier decide or timeout expires, and returns a conn replaying
+// those bytes.
+func sniffTCPConn(conn adapter.TCPConn, timeout time.Duration) (*sniffConn, sniff.Result, sniff.Protocol) {
+	c := &sniffConn{TCPConn: conn}
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, maxSniffSize)
+	var res sniff.Result
+	var hostErr, protoErr error = sniff.ErrIncomplete, sniff.ErrIncomplete
+	proto := sniff.ProtocolUnknown
+	n := 0
+	for n < len(buf) {
+		nr, err := conn.Read(buf[n:])
+		n += nr
+		if errors.Is(hostErr, sniff.ErrIncomplete) {
+			res, hostErr = sniff.Sniff(buf[:n])
+		}
+		if errors.Is(protoErr, sniff.ErrIncomplete) {
+			proto, protoErr = sniff.Classify(buf[:n])
+		}
+		if !errors.Is(hostErr, sniff.ErrIncomplete) && !errors.Is(protoErr, sniff.ErrIncomplete) {
+			break
+		}
+		if err != nil {
+			// the client may just wait for the server to speak first
+			if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+				c.err = err
+			}
+			break
+		}
+	}
+	c.peeked = buf[:n]
+	c.protocol.Store(proto)
+	return c, res, proto
+}
+
+// maxSniffDatagrams is the maximum number of datagrams to reassemble a
+// ClientHello from QUIC Initial packets.
+const maxSniffDatagrams = 4
+
+type datagram struct {
+	data []byte
+	addr net.Addr
+}
+
+// sniffUDPConn replays the datagrams read by the sniffer before reading
+// from the underlying connection.
+type sniffUDPConn struct {
+	adapter.UDPConn
+	peeked []datagram
+	err    error
+}
+
+func (c *sniffUDPConn) Read(b []byte) (int, error) {
+	n, _, err := c.ReadFrom(b)
+	return n, err
+}
+
+func (c *sniffUDPConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	if len(c.peeked) > 0 {
+		d := c.peeked[0]
+		c.peeked = c.peeked[1:]
+		return copy(b, d.data), d.addr, nil
+	}
+	if c.err != nil {
+		return 0, nil, c.err
+	}
+	return c.UDPConn.ReadFrom(b)
+}
+
+// sniffUDPSession reads the first client datagrams of conn until the QUIC
+// sniffer decides or timeout expires, and returns a conn replaying them.
+func sniffUDPSession(conn adapter.UDPConn, timeout time.Duration) (*sniffUDPConn, sniff.Result, sniff.Protocol) {
+	c := &sniffUDPConn{UDPConn: conn}
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	var q sniff.QUIC
+	var res sniff.Result
+	var sniffErr error
+	for len(c.peeked) < maxSniffDatagrams {
+		buf := make([]byte, maxSniffSize)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+				c.err = err
+			}
+			break
+		}
+		c.peeked = append(c.peeked, datagram{data: buf[:n], addr: addr})
+		if res, sniffErr = q.Feed(buf[:n]); !errors.Is(sniffErr, sniff.ErrIncomplete) {
+			break
+		}
+	}
+	proto := sniff.ProtocolUnknown
+	if sniffErr == nil {
+		proto = sniff.ProtocolQUIC
+	}
+	return c, res, proto
+}
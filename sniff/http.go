@@ -0,0 +1,63 @@
+package sniff
+
+import (
+	"bytes"
+	"net"
+	"strings"
+)
+
+var httpMethods = []string{
+	"GET", "HEAD", "POST", "PUT", "DELETE", "CONNECT", "OPTIONS", "TRACE", "PATCH",
+}
+
+// maxHTTPHeaderLen is the maximum size of the request header to look for Host.
+const maxHTTPHeaderLen = 8 << 10
+
+// HTTP extracts the Host header from an HTTP/1 request header.
+func HTTP(b []byte) (Result, error) {
+	if err := matchHTTPMethod(b); err != nil {
+		return Result{}, err
+	}
+	end := bytes.Index(b, []byte("\r\n\r\n"))
+	if end < 0 {
+		if len(b) >= maxHTTPHeaderLen {
+			return Result{}, ErrNotMatched
+		}
+		return Result{}, ErrIncomplete
+	}
+	lines := strings.Split(string(b[:end]), "\r\n")
+	// request line: METHOD SP request-target SP HTTP-version
+	if fields := strings.Fields(lines[0]); len(fields) != 3 || !strings.HasPrefix(fields[2], "HTTP/1.") {
+		return Result{}, ErrNotMatched
+	}
+	for _, line := range lines[1:] {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || !strings.EqualFold(strings.TrimSpace(key), "Host") {
+			continue
+		}
+		host := strings.TrimSpace(value)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		return Result{Host: strings.ToLower(strings.TrimSuffix(host, "."))}, nil
+	}
+	return Result{}, ErrNotMatched
+}
+
+// matchHTTPMethod checks whether b starts with a known method and a space.
+func matchHTTPMethod(b []byte) error {
+	for _, method := range httpMethods {
+		prefix := method + " "
+		if len(b) < len(prefix) {
+			if strings.HasPrefix(prefix, string(b)) {
+				return ErrIncomplete
+			}
+			continue
+		}
+		if string(b[:len(prefix)]) == prefix {
+			return nil
+		}
+	}
+	return ErrNotMatched
+}
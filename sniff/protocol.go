@@ -0,0 +1,133 @@
+package sniff
+
+import (
+	"bytes"
+	"errors"
+)
+
+// Protocol is the application protocol label of a flow.
+type Protocol string
+
+const (
+	ProtocolUnknown    Protocol = "unknown"
+	ProtocolTLS        Protocol = "tls"
+	ProtocolHTTP       Protocol = "http"
+	ProtocolHTTP2      Protocol = "http2"
+	ProtocolSSH        Protocol = "ssh"
+	ProtocolSOCKS      Protocol = "socks"
+	ProtocolBitTorrent Protocol = "bittorrent"
+	ProtocolQUIC       Protocol = "quic"
+)
+
+var (
+	http2Preface      = []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+	sshBanner         = []byte("SSH-")
+	bitTorrentHeader  = []byte("\x13BitTorrent protocol")
+	httpResponseStart = []byte("HTTP/1.")
+)
+
+// Classify labels a TCP flow from its first client bytes.
+func Classify(b []byte) (Protocol, error) {
+	var incomplete bool
+	for _, fn := range []func([]byte) (Protocol, error){
+		classifyTLS,
+		classifyPrefix(http2Preface, ProtocolHTTP2),
+		classifyHTTP,
+		classifyPrefix(sshBanner, ProtocolSSH),
+		classifyPrefix(bitTorrentHeader, ProtocolBitTorrent),
+		classifySOCKS,
+	} {
+		proto, err := fn(b)
+		if err == nil {
+			return proto, nil
+		}
+		if errors.Is(err, ErrIncomplete) {
+			incomplete = true
+		}
+	}
+	if incomplete {
+		return ProtocolUnknown, ErrIncomplete
+	}
+	return ProtocolUnknown, nil
+}
+
+// ClassifyServer labels a TCP flow from its first server bytes, which is
+// useful for the protocols in which the server speaks first.
+func ClassifyServer(b []byte) Protocol {
+	switch {
+	case bytes.HasPrefix(b, sshBanner):
+		return ProtocolSSH
+	case bytes.HasPrefix(b, httpResponseStart):
+		return ProtocolHTTP
+	case bytes.HasPrefix(b, bitTorrentHeader):
+		return ProtocolBitTorrent
+	// handshake record of ServerHello
+	case len(b) >= 6 && b[0] == recordTypeHandshake && b[1] == 0x03 && b[5] == 0x02:
+		return ProtocolTLS
+	}
+	return ProtocolUnknown
+}
+
+func classifyTLS(b []byte) (Protocol, error) {
+	if _, err := TLS(b); err != nil {
+		return ProtocolUnknown, err
+	}
+	return ProtocolTLS, nil
+}
+
+func classifyHTTP(b []byte) (Protocol, error) {
+	if err := matchHTTPMethod(b); err != nil {
+		return ProtocolUnknown, err
+	}
+	return ProtocolHTTP, nil
+}
+
+func classifyPrefix(prefix []byte, proto Protocol) func([]byte) (Protocol, error) {
+	return func(b []byte) (Protocol, error) {
+		if len(b) < len(prefix) {
+			if bytes.HasPrefix(prefix, b) {
+				return ProtocolUnknown, ErrIncomplete
+			}
+			return ProtocolUnknown, ErrNotMatched
+		}
+		if bytes.HasPrefix(b, prefix) {
+			return proto, nil
+		}
+		return ProtocolUnknown, ErrNotMatched
+	}
+}
+
+// classifySOCKS matches the SOCKS5 method selection or the SOCKS4 request.
+func classifySOCKS(b []byte) (Protocol, error) {
+	if len(b) < 2 {
+		if len(b) == 0 || b[0] == 0x05 || b[0] == 0x04 {
+			return ProtocolUnknown, ErrIncomplete
+		}
+		return ProtocolUnknown, ErrNotMatched
+	}
+	switch b[0] {
+	case 0x05:
+		// VER, NMETHODS, METHODS
+		nmethods := int(b[1])
+		if nmethods == 0 || len(b) > 2+nmethods {
+			return ProtocolUnknown, ErrNotMatched
+		}
+		if len(b) < 2+nmethods {
+			return ProtocolUnknown, ErrIncomplete
+		}
+		return ProtocolSOCKS, nil
+	case 0x04:
+		// VN, CD (CONNECT or BIND), DSTPORT, DSTIP, USERID, NULL
+		if b[1] != 0x01 && b[1] != 0x02 {
+			return ProtocolUnknown, ErrNotMatched
+		}
+		if len(b) < 9 {
+			return ProtocolUnknown, ErrIncomplete
+		}
+		if b[len(b)-1] != 0x00 {
+			return ProtocolUnknown, ErrNotMatched
+		}
+		return ProtocolSOCKS, nil
+	}
+	return ProtocolUnknown, ErrNotMatched
+}
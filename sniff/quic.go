@@ -0,0 +1,273 @@
+package sniff
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+)
+
+const (
+	quicVersion1 = 0x00000001
+	quicVersion2 = 0x6b3343cf
+
+	// maxCryptoDataLen bounds the reassembled CRYPTO stream.
+	maxCryptoDataLen = 1 << 16
+)
+
+var (
+	quicSaltV1 = []byte{
+		0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17,
+		0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a,
+	}
+	quicSaltV2 = []byte{
+		0x0d, 0xed, 0xe3, 0xde, 0xf7, 0x00, 0xa6, 0xdb, 0x81, 0x93,
+		0x81, 0xbe, 0x6e, 0x26, 0x9d, 0xcb, 0xf9, 0xbd, 0x2e, 0xd9,
+	}
+)
+
+// QUIC reassembles the ClientHello from the CRYPTO frames of the client
+// Initial packets of a QUIC v1 (RFC 9001) or v2 (RFC 9369) connection. A
+// ClientHello may span several datagrams, so the datagrams are fed one by
+// one until Feed stops returning ErrIncomplete.
+type QUIC struct {
+	frames []cryptoFrame
+}
+
+type cryptoFrame struct {
+	offset uint64
+	data   []byte
+}
+
+// Feed decrypts the Initial packets coalesced in a client datagram.
+func (q *QUIC) Feed(datagram []byte) (Result, error) {
+	var found bool
+	for len(datagram) > 0 {
+		n, err := q.feedPacket(datagram)
+		if err != nil {
+			if found {
+				break /* trailing non-Initial packets */
+			}
+			return Result{}, err
+		}
+		found = true
+		datagram = datagram[n:]
+	}
+	return q.clientHello()
+}
+
+// feedPacket decrypts the first long header packet in b and returns its size.
+func (q *QUIC) feedPacket(b []byte) (int, error) {
+	// header form, fixed bit
+	if len(b) < 7 || b[0]&0xc0 != 0xc0 {
+		return 0, ErrNotMatched
+	}
+	version := binary.BigEndian.Uint32(b[1:5])
+	var salt []byte
+	var labelPrefix string
+	switch version {
+	case quicVersion1:
+		if (b[0]>>4)&0x03 != 0x00 {
+			return 0, ErrNotMatched
+		}
+		salt, labelPrefix = quicSaltV1, "quic "
+	case quicVersion2:
+		if (b[0]>>4)&0x03 != 0x01 {
+			return 0, ErrNotMatched
+		}
+		salt, labelPrefix = quicSaltV2, "quicv2 "
+	default:
+		return 0, ErrNotMatched
+	}
+
+	r := reader(b[5:])
+	dcid, ok := r.prefixed(1)
+	if !ok || len(dcid) > 20 {
+		return 0, ErrNotMatched
+	}
+	if scid, ok := r.prefixed(1); !ok || len(scid) > 20 {
+		return 0, ErrNotMatched
+	}
+	tokenLen, ok := r.varint()
+	if !ok || !r.skip(int(tokenLen)) {
+		return 0, ErrNotMatched
+	}
+	length, ok := r.varint()
+	if !ok || uint64(len(r)) < length {
+		return 0, ErrNotMatched
+	}
+	pnOffset := len(b) - len(r)
+	end := pnOffset + int(length)
+	if length < 4+16 {
+		return 0, ErrNotMatched
+	}
+
+	initialSecret := hkdfExtract(salt, dcid)
+	secret := hkdfExpandLabel(initialSecret, "client in", 32)
+	key := hkdfExpandLabel(secret, labelPrefix+"key", 16)
+	iv := hkdfExpandLabel(secret, labelPrefix+"iv", 12)
+	hp := hkdfExpandLabel(secret, labelPrefix+"hp", 16)
+
+	// remove header protection, without touching the caller's buffer
+	packet := append([]byte(nil), b[:end]...)
+	block, err := aes.NewCipher(hp)
+	if err != nil {
+		return 0, err
+	}
+	var mask [16]byte
+	block.Encrypt(mask[:], packet[pnOffset+4:pnOffset+4+16])
+	packet[0] ^= mask[0] & 0x0f
+	pnLen := int(packet[0]&0x03) + 1
+	var pn uint64
+	for i := 0; i < pnLen; i++ {
+		packet[pnOffset+i] ^= mask[1+i]
+		pn = pn<<8 | uint64(packet[pnOffset+i])
+	}
+
+	block, err = aes.NewCipher(key)
+	if err != nil {
+		return 0, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return 0, err
+	}
+	nonce := iv
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * i))
+	}
+	header := packet[:pnOffset+pnLen]
+	payload, err := aead.Open(nil, nonce, packet[pnOffset+pnLen:], header)
+	if err != nil {
+		return 0, ErrNotMatched
+	}
+	if err = q.parseFrames(payload); err != nil {
+		return 0, err
+	}
+	return end, nil
+}
+
+func (q *QUIC) parseFrames(b []byte) error {
+	r := reader(b)
+	for len(r) > 0 {
+		typ, ok := r.varint()
+		if !ok {
+			// a truncated varint consumes nothing
+			return ErrNotMatched
+		}
+		switch typ {
+		case 0x00, 0x01: // PADDING, PING
+		case 0x02, 0x03: // ACK
+			var ranges uint64
+			ok := true
+			for i := 0; i < 4 && ok; i++ {
+				// largest acknowledged, delay, range count, first range
+				var v uint64
+				v, ok = r.varint()
+				if i == 2 {
+					ranges = v
+				}
+			}
+			for i := uint64(0); i < ranges*2 && ok; i++ {
+				_, ok = r.varint()
+			}
+			for i := 0; typ == 0x03 && i < 3 && ok; i++ {
+				_, ok = r.varint()
+			}
+			if !ok {
+				return ErrNotMatched
+			}
+		case 0x06: // CRYPTO
+			offset, ok := r.varint()
+			if !ok {
+				return ErrNotMatched
+			}
+			length, ok := r.varint()
+			if !ok || offset+length > maxCryptoDataLen {
+				return ErrNotMatched
+			}
+			data, ok := r.read(int(length))
+			if !ok {
+				return ErrNotMatched
+			}
+			q.frames = append(q.frames, cryptoFrame{offset: offset, data: data})
+		case 0x1c: // CONNECTION_CLOSE
+			return ErrNotMatched
+		default:
+			// no other frame is allowed in client Initial packets
+			return ErrNotMatched
+		}
+	}
+	return nil
+}
+
+// clientHello parses the reassembled CRYPTO stream.
+func (q *QUIC) clientHello() (Result, error) {
+	sort.Slice(q.frames, func(i, j int) bool { return q.frames[i].offset < q.frames[j].offset })
+	var stream []byte
+	for _, frame := range q.frames {
+		if frame.offset > uint64(len(stream)) {
+			break /* gap */
+		}
+		if end := frame.offset + uint64(len(frame.data)); end > uint64(len(stream)) {
+			stream = append(stream, frame.data[uint64(len(stream))-frame.offset:]...)
+		}
+	}
+	if len(stream) < handshakeHeaderLen {
+		return Result{}, ErrIncomplete
+	}
+	if stream[0] != handshakeTypeClientHello {
+		return Result{}, ErrNotMatched
+	}
+	helloLen := int(stream[1])<<16 | int(stream[2])<<8 | int(stream[3])
+	if len(stream) < handshakeHeaderLen+helloLen {
+		return Result{}, ErrIncomplete
+	}
+	return ClientHello(stream[handshakeHeaderLen : handshakeHeaderLen+helloLen])
+}
+
+func (r *reader) varint() (uint64, bool) {
+	if len(*r) == 0 {
+		return 0, false
+	}
+	n := 1 << ((*r)[0] >> 6)
+	b, ok := r.read(n)
+	if !ok {
+		return 0, false
+	}
+	v := uint64(b[0] & 0x3f)
+	for _, c := range b[1:] {
+		v = v<<8 | uint64(c)
+	}
+	return v, true
+}
+
+func hkdfExtract(salt, secret []byte) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(secret)
+	return mac.Sum(nil)
+}
+
+// hkdfExpandLabel implements HKDF-Expand-Label of TLS 1.3 with empty context.
+func hkdfExpandLabel(secret []byte, label string, length int) []byte {
+	info := make([]byte, 0, 4+6+len(label))
+	info = binary.BigEndian.AppendUint16(info, uint16(length))
+	info = append(info, byte(6+len(label)))
+	info = append(info, "tls13 "...)
+	info = append(info, label...)
+	info = append(info, 0)
+
+	var out, prev []byte
+	mac := hmac.New(sha256.New, secret)
+	for i := byte(1); len(out) < length; i++ {
+		mac.Reset()
+		mac.Write(prev)
+		mac.Write(info)
+		mac.Write([]byte{i})
+		prev = mac.Sum(nil)
+		out = append(out, prev...)
+	}
+	return out[:length]
+}
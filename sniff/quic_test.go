@@ -0,0 +1,147 @@
+package sniff
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQUICInitialKeys(t *testing.T) {
+	// RFC 9001 A.1 and RFC 9369 A.1
+	dcid, _ := hex.DecodeString("8394c8f03e515708")
+	for _, tt := range []struct {
+		salt        []byte
+		prefix      string
+		key, iv, hp string
+	}{
+		{quicSaltV1, "quic ", "1f369613dd76d5467730efcbe3b1a22d", "fa044b2f42a3fd3b46fb255c", "9f50449e04a0e810283a1e9933adedd2"},
+		{quicSaltV2, "quicv2 ", "8b1a0bc121284290a29e0971b5cd045d", "91f73e2351d8fa91660e909f", "45b95e15235d6f45a6b19cbcb0294ba9"},
+	} {
+		secret := hkdfExpandLabel(hkdfExtract(tt.salt, dcid), "client in", 32)
+		if key := hex.EncodeToString(hkdfExpandLabel(secret, tt.prefix+"key", 16)); key != tt.key {
+			t.Errorf("%skey: %s", tt.prefix, key)
+		}
+		if iv := hex.EncodeToString(hkdfExpandLabel(secret, tt.prefix+"iv", 12)); iv != tt.iv {
+			t.Errorf("%siv: %s", tt.prefix, iv)
+		}
+		if hp := hex.EncodeToString(hkdfExpandLabel(secret, tt.prefix+"hp", 16)); hp != tt.hp {
+			t.Errorf("%shp: %s", tt.prefix, hp)
+		}
+	}
+}
+
+// sealInitial builds a protected client Initial packet carrying frames.
+func sealInitial(t *testing.T, version uint32, pn uint32, frames []byte) []byte {
+	dcid, _ := hex.DecodeString("8394c8f03e515708")
+	salt, prefix, typ := quicSaltV1, "quic ", byte(0x00)
+	if version == quicVersion2 {
+		salt, prefix, typ = quicSaltV2, "quicv2 ", 0x01
+	}
+	secret := hkdfExpandLabel(hkdfExtract(salt, dcid), "client in", 32)
+	key := hkdfExpandLabel(secret, prefix+"key", 16)
+	iv := hkdfExpandLabel(secret, prefix+"iv", 12)
+	hp := hkdfExpandLabel(secret, prefix+"hp", 16)
+
+	// pad to the minimum size of the client Initial datagram
+	frames = append(frames, make([]byte, max(0, 1162-len(frames)))...)
+	length := 4 + len(frames) + 16
+
+	header := []byte{0xc0 | typ<<4 | 0x03}
+	header = binary.BigEndian.AppendUint32(header, version)
+	header = append(header, byte(len(dcid)))
+	header = append(header, dcid...)
+	header = append(header, 0) // scid
+	header = append(header, 0) // token length
+	header = binary.BigEndian.AppendUint16(header, 0x4000|uint16(length))
+	pnOffset := len(header)
+	header = binary.BigEndian.AppendUint32(header, pn)
+
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+	nonce := append([]byte(nil), iv...)
+	for i := 0; i < 4; i++ {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * i))
+	}
+	packet := aead.Seal(header, nonce, frames, header)
+
+	block, _ = aes.NewCipher(hp)
+	var mask [16]byte
+	block.Encrypt(mask[:], packet[pnOffset+4:pnOffset+20])
+	packet[0] ^= mask[0] & 0x0f
+	for i := 0; i < 4; i++ {
+		packet[pnOffset+i] ^= mask[1+i]
+	}
+	return packet
+}
+
+func cryptoFrameBytes(offset int, data []byte) []byte {
+	b := []byte{0x06}
+	b = binary.BigEndian.AppendUint32(b, 0x80000000|uint32(offset))
+	b = binary.BigEndian.AppendUint32(b, 0x80000000|uint32(len(data)))
+	return append(b, data...)
+}
+
+func TestSniffQUIC(t *testing.T) {
+	record := clientHello(t, &tls.Config{ServerName: "www.example.com", NextProtos: []string{"h3"}})
+	hello := record[recordHeaderLen:]
+	half := len(hello) / 2
+
+	for _, version := range []uint32{quicVersion1, quicVersion2} {
+		// the second half arrives first in another datagram
+		var q QUIC
+		_, err := q.Feed(sealInitial(t, version, 1, cryptoFrameBytes(half, hello[half:])))
+		if !errors.Is(err, ErrIncomplete) {
+			t.Fatalf("first datagram: %v", err)
+		}
+		frames := append([]byte{0x01}, cryptoFrameBytes(0, hello[:half])...)
+		res, err := q.Feed(sealInitial(t, version, 0, frames))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Host != "www.example.com" || len(res.ALPN) != 1 || res.ALPN[0] != "h3" {
+			t.Fatalf("unexpected result %+v", res)
+		}
+	}
+
+	var q QUIC
+	if _, err := q.Feed([]byte("not a quic packet")); !errors.Is(err, ErrNotMatched) {
+		t.Fatalf("garbage: %v", err)
+	}
+}
+
+func TestSniffQUICTruncatedFrameType(t *testing.T) {
+	// the frames end with the first byte of a 2 bytes varint
+	frames := append(make([]byte, 1161), 0x40)
+	packet := sealInitial(t, quicVersion1, 0, frames)
+
+	done := make(chan error, 1)
+	go func() {
+		var q QUIC
+		_, err := q.Feed(packet)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrNotMatched) {
+			t.Fatalf("Feed = %v, want ErrNotMatched", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Feed doesn't return on a truncated frame type")
+	}
+}
+
+func FuzzQUICParseFrames(f *testing.F) {
+	f.Add([]byte{0x40})
+	f.Add([]byte{0x00, 0x01, 0x80})
+	f.Add(cryptoFrameBytes(0, []byte{0x01, 0x00, 0x00, 0x01, 0x03}))
+	f.Add([]byte{0x02, 0x00, 0x00, 0x01, 0x00, 0x00})
+	f.Fuzz(func(t *testing.T, frames []byte) {
+		var q QUIC
+		_ = q.parseFrames(frames)
+	})
+}
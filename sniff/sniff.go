@@ -0,0 +1,103 @@
+// Package sniff extracts the destination domain and the application
+// protocol from the first bytes of a connection.
+package sniff
+
+import "errors"
+
+var (
+	// ErrIncomplete means more bytes are needed to decide.
+	ErrIncomplete = errors.New("sniff: incomplete data")
+
+	// ErrNotMatched means the bytes don't belong to the protocol.
+	ErrNotMatched = errors.New("sniff: protocol not matched")
+)
+
+// Result is the sniffed information of a connection.
+type Result struct {
+	// Host is the TLS server name or the HTTP Host without port.
+	Host string
+	// ALPN is the application protocols offered in TLS ClientHello.
+	ALPN []string
+}
+
+// Sniff extracts the host from the first client bytes of a TCP stream,
+// trying TLS ClientHello and then HTTP/1 request header.
+func Sniff(b []byte) (Result, error) {
+	var incomplete bool
+	for _, fn := range []func([]byte) (Result, error){TLS, HTTP} {
+		res, err := fn(b)
+		if err == nil {
+			return res, nil
+		}
+		if errors.Is(err, ErrIncomplete) {
+			incomplete = true
+		}
+	}
+	if incomplete {
+		return Result{}, ErrIncomplete
+	}
+	return Result{}, ErrNotMatched
+}
+
+// reader is a helper to parse the length-prefixed fields.
+type reader []byte
+
+func (r *reader) skip(n int) bool {
+	if len(*r) < n {
+		return false
+	}
+	*r = (*r)[n:]
+	return true
+}
+
+func (r *reader) read(n int) ([]byte, bool) {
+	if len(*r) < n {
+		return nil, false
+	}
+	b := (*r)[:n]
+	*r = (*r)[n:]
+	return b, true
+}
+
+func (r *reader) uint8() (int, bool) {
+	b, ok := r.read(1)
+	if !ok {
+		return 0, false
+	}
+	return int(b[0]), true
+}
+
+func (r *reader) uint16() (int, bool) {
+	b, ok := r.read(2)
+	if !ok {
+		return 0, false
+	}
+	return int(b[0])<<8 | int(b[1]), true
+}
+
+func (r *reader) uint24() (int, bool) {
+	b, ok := r.read(3)
+	if !ok {
+		return 0, false
+	}
+	return int(b[0])<<16 | int(b[1])<<8 | int(b[2]), true
+}
+
+// prefixed reads a field prefixed by its n-byte length.
+func (r *reader) prefixed(n int) (reader, bool) {
+	var length int
+	var ok bool
+	switch n {
+	case 1:
+		length, ok = r.uint8()
+	case 2:
+		length, ok = r.uint16()
+	case 3:
+		length, ok = r.uint24()
+	}
+	if !ok {
+		return nil, false
+	}
+	b, ok := r.read(length)
+	return reader(b), ok
+}
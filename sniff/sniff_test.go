@@ -0,0 +1,100 @@
+package sniff
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+)
+
+// clientHello captures the ClientHello written by crypto/tls.
+func clientHello(t *testing.T, config *tls.Config) []byte {
+	client, server := net.Pipe()
+	go func() {
+		tls.Client(client, config).Handshake()
+		client.Close()
+	}()
+	buf := make([]byte, 1<<16)
+	var hello []byte
+	for {
+		n, err := server.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hello = append(hello, buf[:n]...)
+		if _, err = TLS(hello); !errors.Is(err, ErrIncomplete) {
+			break
+		}
+	}
+	server.Close()
+	return hello
+}
+
+func TestSniffTLS(t *testing.T) {
+	hello := clientHello(t, &tls.Config{
+		ServerName: "Example.COM",
+		NextProtos: []string{"h2", "http/1.1"},
+	})
+
+	for i := 0; i < len(hello); i += 64 {
+		if _, err := Sniff(hello[:i]); !errors.Is(err, ErrIncomplete) {
+			t.Fatalf("truncated ClientHello of %d bytes: %v", i, err)
+		}
+	}
+	res, err := Sniff(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Host != "example.com" || !slices.Equal(res.ALPN, []string{"h2", "http/1.1"}) {
+		t.Fatalf("unexpected result %+v", res)
+	}
+}
+
+func TestSniffHTTP(t *testing.T) {
+	req := []byte("GET /index.html HTTP/1.1\r\nUser-Agent: test\r\nhost: www.Example.com:8080\r\n\r\n")
+	if _, err := Sniff(req[:20]); !errors.Is(err, ErrIncomplete) {
+		t.Fatalf("truncated request: %v", err)
+	}
+	res, err := Sniff(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Host != "www.example.com" {
+		t.Fatalf("unexpected host %q", res.Host)
+	}
+	if _, err = Sniff([]byte("SSH-2.0-OpenSSH_9.6\r\n")); !errors.Is(err, ErrNotMatched) {
+		t.Fatalf("ssh banner: %v", err)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	for _, tt := range []struct {
+		data  string
+		proto Protocol
+	}{
+		{"PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n", ProtocolHTTP2},
+		{"GET / HTTP/1.1\r\n", ProtocolHTTP},
+		{"SSH-2.0-OpenSSH_9.6\r\n", ProtocolSSH},
+		{"\x05\x02\x00\x02", ProtocolSOCKS},
+		{"\x04\x01\x00\x50\x01\x02\x03\x04user\x00", ProtocolSOCKS},
+		{"\x13BitTorrent protocol\x00\x00\x00\x00\x00\x00\x00\x00", ProtocolBitTorrent},
+		{"\x00\x01\x02\x03\x04\x05", ProtocolUnknown},
+	} {
+		proto, err := Classify([]byte(tt.data))
+		if err != nil || proto != tt.proto {
+			t.Errorf("%q: %s %v", tt.data, proto, err)
+		}
+	}
+
+	hello := clientHello(t, &tls.Config{ServerName: "example.com"})
+	if proto, err := Classify(hello); err != nil || proto != ProtocolTLS {
+		t.Errorf("ClientHello: %s %v", proto, err)
+	}
+	if _, err := Classify([]byte("PRI * HTTP")); !errors.Is(err, ErrIncomplete) {
+		t.Errorf("truncated preface: %v", err)
+	}
+	if proto := ClassifyServer([]byte("SSH-2.0-dropbear\r\n")); proto != ProtocolSSH {
+		t.Errorf("server banner: %s", proto)
+	}
+}
@@ -0,0 +1,126 @@
+package sniff
+
+import "strings"
+
+const (
+	recordTypeHandshake       = 0x16
+	handshakeTypeClientHello  = 0x01
+	recordHeaderLen           = 5
+	handshakeHeaderLen        = 4
+	maxClientHelloLen         = 1 << 16
+	extensionServerName       = 0
+	extensionALPN             = 16
+	serverNameTypeHostName    = 0
+	maxPlaintextRecordPayload = 1 << 14
+)
+
+// TLS extracts the server name and ALPN from a TLS ClientHello which may
+// span several records.
+func TLS(b []byte) (Result, error) {
+	var hello []byte
+	for {
+		if len(b) < recordHeaderLen {
+			return Result{}, ErrIncomplete
+		}
+		// content type and the major version of the record
+		if b[0] != recordTypeHandshake || b[1] != 0x03 {
+			return Result{}, ErrNotMatched
+		}
+		length := int(b[3])<<8 | int(b[4])
+		if length == 0 || length > maxPlaintextRecordPayload {
+			return Result{}, ErrNotMatched
+		}
+		if len(b) < recordHeaderLen+length {
+			// the first fragment is enough to tell it's not a ClientHello
+			if hello == nil && len(b) > recordHeaderLen && b[recordHeaderLen] != handshakeTypeClientHello {
+				return Result{}, ErrNotMatched
+			}
+			return Result{}, ErrIncomplete
+		}
+		hello = append(hello, b[recordHeaderLen:recordHeaderLen+length]...)
+		b = b[recordHeaderLen+length:]
+
+		if hello[0] != handshakeTypeClientHello {
+			return Result{}, ErrNotMatched
+		}
+		if len(hello) < handshakeHeaderLen {
+			continue
+		}
+		helloLen := int(hello[1])<<16 | int(hello[2])<<8 | int(hello[3])
+		if helloLen > maxClientHelloLen {
+			return Result{}, ErrNotMatched
+		}
+		if len(hello) >= handshakeHeaderLen+helloLen {
+			return ClientHello(hello[handshakeHeaderLen : handshakeHeaderLen+helloLen])
+		}
+	}
+}
+
+// ClientHello extracts the server name and ALPN from the body of a
+// ClientHello handshake message. The server name is empty if the client
+// didn't send SNI.
+func ClientHello(b []byte) (Result, error) {
+	r := reader(b)
+	var res Result
+	// legacy_version, random
+	if !r.skip(2 + 32) {
+		return res, ErrNotMatched
+	}
+	// legacy_session_id, cipher_suites, legacy_compression_methods
+	if _, ok := r.prefixed(1); !ok {
+		return res, ErrNotMatched
+	}
+	if _, ok := r.prefixed(2); !ok {
+		return res, ErrNotMatched
+	}
+	if _, ok := r.prefixed(1); !ok {
+		return res, ErrNotMatched
+	}
+	if len(r) == 0 {
+		return res, ErrNotMatched /* no extensions */
+	}
+	exts, ok := r.prefixed(2)
+	if !ok {
+		return res, ErrNotMatched
+	}
+	for len(exts) > 0 {
+		typ, ok := exts.uint16()
+		if !ok {
+			return res, ErrNotMatched
+		}
+		data, ok := exts.prefixed(2)
+		if !ok {
+			return res, ErrNotMatched
+		}
+		switch typ {
+		case extensionServerName:
+			names, ok := data.prefixed(2)
+			if !ok {
+				return res, ErrNotMatched
+			}
+			for len(names) > 0 {
+				nameType, _ := names.uint8()
+				name, ok := names.prefixed(2)
+				if !ok {
+					return res, ErrNotMatched
+				}
+				if nameType == serverNameTypeHostName {
+					res.Host = strings.ToLower(strings.TrimSuffix(string(name), "."))
+				}
+			}
+		case extensionALPN:
+			protos, ok := data.prefixed(2)
+			if !ok {
+				return res, ErrNotMatched
+			}
+			for len(protos) > 0 {
+				proto, ok := protos.prefixed(1)
+				if !ok {
+					return res, ErrNotMatched
+				}
+				res.ALPN = append(res.ALPN, string(proto))
+			}
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,74 @@
+package netstackgo
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/josexy/netstackgo/sniff"
+	"github.com/josexy/netstackgo/tun/core/adapter"
+)
+
+// pipeTCPConn is an adapter.TCPConn over one end of net.Pipe, the methods
+// other than those of net.Conn are not implemented.
+type pipeTCPConn struct {
+	adapter.TCPConn
+	net.Conn
+}
+
+func (c pipeTCPConn) Read(b []byte) (int, error)         { return c.Conn.Read(b) }
+func (c pipeTCPConn) Write(b []byte) (int, error)        { return c.Conn.Write(b) }
+func (c pipeTCPConn) Close() error                       { return c.Conn.Close() }
+func (c pipeTCPConn) LocalAddr() net.Addr                { return c.Conn.LocalAddr() }
+func (c pipeTCPConn) RemoteAddr() net.Addr               { return c.Conn.RemoteAddr() }
+func (c pipeTCPConn) SetDeadline(t time.Time) error      { return c.Conn.SetDeadline(t) }
+func (c pipeTCPConn) SetReadDeadline(t time.Time) error  { return c.Conn.SetReadDeadline(t) }
+func (c pipeTCPConn) SetWriteDeadline(t time.Time) error { return c.Conn.SetWriteDeadline(t) }
+
+func TestSniffTCPConnReplay(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		first []byte
+		host  string
+		proto sniff.Protocol
+	}{
+		{
+			name:  "http",
+			first: []byte("POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5\r\n\r\n"),
+			host:  "example.com",
+			proto: sniff.ProtocolHTTP,
+		},
+		{
+			// nothing is decided until the timeout
+			name:  "partial",
+			first: []byte("\x16\x03"),
+			proto: sniff.ProtocolUnknown,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			rest := []byte("hello")
+			go func() {
+				client.Write(tt.first)
+				time.Sleep(200 * time.Millisecond)
+				client.Write(rest)
+				client.Close()
+			}()
+
+			conn, res, proto := sniffTCPConn(pipeTCPConn{Conn: server}, 100*time.Millisecond)
+			if res.Host != tt.host || proto != tt.proto {
+				t.Errorf("sniffed %q, %s, want %q, %s", res.Host, proto, tt.host, tt.proto)
+			}
+			got, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := append(bytes.Clone(tt.first), rest...); !bytes.Equal(got, want) {
+				t.Errorf("replayed %q, want %q", got, want)
+			}
+		})
+	}
+}
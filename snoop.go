@@ -0,0 +1,53 @@
+package netstackgo
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/josexy/netstackgo/dns"
+	"github.com/josexy/netstackgo/tun/core/adapter"
+)
+
+// snoopUDPConn feeds the DNS responses written back to the client to the snooper.
+type snoopUDPConn struct {
+	adapter.UDPConn
+	snooper *dns.Snooper
+}
+
+func (c *snoopUDPConn) Write(b []byte) (int, error) {
+	c.snooper.Observe(b)
+	return c.UDPConn.Write(b)
+}
+
+func (c *snoopUDPConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.snooper.Observe(b)
+	return c.UDPConn.WriteTo(b, addr)
+}
+
+// snoopTCPConn reassembles the length-prefixed DNS responses written back
+// to the client and feeds them to the snooper.
+type snoopTCPConn struct {
+	adapter.TCPConn
+	snooper *dns.Snooper
+	buf     []byte
+}
+
+func (c *snoopTCPConn) Write(b []byte) (int, error) {
+	c.observe(b)
+	return c.TCPConn.Write(b)
+}
+
+func (c *snoopTCPConn) observe(b []byte) {
+	c.buf = append(c.buf, b...)
+	for len(c.buf) >= 2 {
+		n := int(binary.BigEndian.Uint16(c.buf)) + 2
+		if len(c.buf) < n {
+			return
+		}
+		c.snooper.Observe(c.buf[2:n])
+		c.buf = c.buf[n:]
+	}
+	if len(c.buf) == 0 {
+		c.buf = nil
+	}
+}
@@ -0,0 +1,169 @@
+package netstackgo
+
+import (
+	"reflect"
+
+	"github.com/josexy/netstackgo/tun/core/device"
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// Stats is a snapshot of the counters of the netstack and the TUN device.
+type Stats struct {
+	IP     IPStats
+	TCP    TCPStats
+	UDP    UDPStats
+	ICMP   ICMPStats
+	Device device.Stats
+}
+
+// IPStats is the IPv4 and IPv6 counters of the netstack.
+type IPStats struct {
+	PacketsReceived                     uint64
+	ValidPacketsReceived                uint64
+	InvalidDestinationAddressesReceived uint64
+	InvalidSourceAddressesReceived      uint64
+	MalformedPacketsReceived            uint64
+	MalformedFragmentsReceived          uint64
+	PacketsDelivered                    uint64
+	PacketsSent                         uint64
+	OutgoingPacketErrors                uint64
+}
+
+// TCPStats is the TCP counters of the netstack.
+type TCPStats struct {
+	ActiveConnectionOpenings  uint64
+	PassiveConnectionOpenings uint64
+	// CurrentEstablished and CurrentConnected are gauges, which are kept
+	// as is by Stats.Sub.
+	CurrentEstablished       uint64 `stats:"gauge"`
+	CurrentConnected         uint64 `stats:"gauge"`
+	EstablishedResets        uint64
+	EstablishedClosed        uint64
+	EstablishedTimedout      uint64
+	ListenOverflowSynDrop    uint64
+	ListenOverflowAckDrop    uint64
+	FailedConnectionAttempts uint64
+	ValidSegmentsReceived    uint64
+	InvalidSegmentsReceived  uint64
+	SegmentsSent             uint64
+	SegmentSendErrors        uint64
+	ResetsSent               uint64
+	ResetsReceived           uint64
+	Retransmits              uint64
+	FastRetransmit           uint64
+	Timeouts                 uint64
+	ChecksumErrors           uint64
+}
+
+// UDPStats is the UDP counters of the netstack.
+type UDPStats struct {
+	PacketsReceived          uint64
+	UnknownPortErrors        uint64
+	ReceiveBufferErrors      uint64
+	MalformedPacketsReceived uint64
+	PacketsSent              uint64
+	PacketSendErrors         uint64
+	ChecksumErrors           uint64
+}
+
+// ICMPStats is the ICMPv4 and ICMPv6 counters of the netstack.
+type ICMPStats struct {
+	EchoRequestsReceived   uint64
+	EchoRepliesSent        uint64
+	DstUnreachableSent     uint64
+	InvalidPacketsReceived uint64
+	PacketsDropped         uint64
+	PacketsRateLimited     uint64
+}
+
+// Stats returns the snapshot of the counters, the netstack counters are zero
+// if the netstack is not started.
+func (ns *TunNetstack) Stats() Stats {
+	var stats Stats
+	if ns.tunDevice != nil {
+		stats.Device = ns.tunDevice.Stats()
+	}
+	if ns.netstack == nil {
+		return stats
+	}
+	s := ns.netstack.Stats()
+
+	stats.IP = IPStats{
+		PacketsReceived:                     s.IP.PacketsReceived.Value(),
+		ValidPacketsReceived:                s.IP.ValidPacketsReceived.Value(),
+		InvalidDestinationAddressesReceived: s.IP.InvalidDestinationAddressesReceived.Value(),
+		InvalidSourceAddressesReceived:      s.IP.InvalidSourceAddressesReceived.Value(),
+		MalformedPacketsReceived:            s.IP.MalformedPacketsReceived.Value(),
+		MalformedFragmentsReceived:          s.IP.MalformedFragmentsReceived.Value(),
+		PacketsDelivered:                    s.IP.PacketsDelivered.Value(),
+		PacketsSent:                         s.IP.PacketsSent.Value(),
+		OutgoingPacketErrors:                s.IP.OutgoingPacketErrors.Value(),
+	}
+	stats.TCP = TCPStats{
+		ActiveConnectionOpenings:  s.TCP.ActiveConnectionOpenings.Value(),
+		PassiveConnectionOpenings: s.TCP.PassiveConnectionOpenings.Value(),
+		CurrentEstablished:        s.TCP.CurrentEstablished.Value(),
+		CurrentConnected:          s.TCP.CurrentConnected.Value(),
+		EstablishedResets:         s.TCP.EstablishedResets.Value(),
+		EstablishedClosed:         s.TCP.EstablishedClosed.Value(),
+		EstablishedTimedout:       s.TCP.EstablishedTimedout.Value(),
+		ListenOverflowSynDrop:     s.TCP.ListenOverflowSynDrop.Value(),
+		ListenOverflowAckDrop:     s.TCP.ListenOverflowAckDrop.Value(),
+		FailedConnectionAttempts:  s.TCP.FailedConnectionAttempts.Value(),
+		ValidSegmentsReceived:     s.TCP.ValidSegmentsReceived.Value(),
+		InvalidSegmentsReceived:   s.TCP.InvalidSegmentsReceived.Value(),
+		SegmentsSent:              s.TCP.SegmentsSent.Value(),
+		SegmentSendErrors:         s.TCP.SegmentSendErrors.Value(),
+		ResetsSent:                s.TCP.ResetsSent.Value(),
+		ResetsReceived:            s.TCP.ResetsReceived.Value(),
+		Retransmits:               s.TCP.Retransmits.Value(),
+		FastRetransmit:            s.TCP.FastRetransmit.Value(),
+		Timeouts:                  s.TCP.Timeouts.Value(),
+		ChecksumErrors:            s.TCP.ChecksumErrors.Value(),
+	}
+	stats.UDP = UDPStats{
+		PacketsReceived:          s.UDP.PacketsReceived.Value(),
+		UnknownPortErrors:        s.UDP.UnknownPortErrors.Value(),
+		ReceiveBufferErrors:      s.UDP.ReceiveBufferErrors.Value(),
+		MalformedPacketsReceived: s.UDP.MalformedPacketsReceived.Value(),
+		PacketsSent:              s.UDP.PacketsSent.Value(),
+		PacketSendErrors:         s.UDP.PacketSendErrors.Value(),
+		ChecksumErrors:           s.UDP.ChecksumErrors.Value(),
+	}
+	stats.ICMP = newICMPStats(&s.ICMP)
+	return stats
+}
+
+func newICMPStats(s *tcpip.ICMPStats) ICMPStats {
+	v4, v6 := &s.V4, &s.V6
+	return ICMPStats{
+		EchoRequestsReceived:   v4.PacketsReceived.EchoRequest.Value() + v6.PacketsReceived.EchoRequest.Value(),
+		EchoRepliesSent:        v4.PacketsSent.EchoReply.Value() + v6.PacketsSent.EchoReply.Value(),
+		DstUnreachableSent:     v4.PacketsSent.DstUnreachable.Value() + v6.PacketsSent.DstUnreachable.Value(),
+		InvalidPacketsReceived: v4.PacketsReceived.Invalid.Value() + v6.PacketsReceived.Invalid.Value(),
+		PacketsDropped:         v4.PacketsSent.Dropped.Value() + v6.PacketsSent.Dropped.Value(),
+		PacketsRateLimited:     v4.PacketsSent.RateLimited.Value() + v6.PacketsSent.RateLimited.Value(),
+	}
+}
+
+// Sub returns the counters increased since the earlier snapshot prev. The
+// gauges are the values of s.
+func (s Stats) Sub(prev Stats) Stats {
+	subStats(reflect.ValueOf(&s).Elem(), reflect.ValueOf(prev))
+	return s
+}
+
+func subStats(cur, prev reflect.Value) {
+	for i := 0; i < cur.NumField(); i++ {
+		field := cur.Type().Field(i)
+		switch field.Type.Kind() {
+		case reflect.Struct:
+			subStats(cur.Field(i), prev.Field(i))
+		case reflect.Uint64:
+			if field.Tag.Get("stats") == "gauge" {
+				continue
+			}
+			cur.Field(i).SetUint(cur.Field(i).Uint() - prev.Field(i).Uint())
+		}
+	}
+}
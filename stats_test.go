@@ -0,0 +1,110 @@
+package netstackgo
+
+import (
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestStatsSub(t *testing.T) {
+	prev := Stats{}
+	prev.TCP.SegmentsSent = 10
+	prev.TCP.CurrentEstablished = 3
+	prev.Device.PacketsRead = 5
+
+	cur := prev
+	cur.TCP.SegmentsSent = 25
+	cur.TCP.CurrentEstablished = 2
+	cur.Device.PacketsRead = 9
+	cur.UDP.PacketsSent = 1
+
+	delta := cur.Sub(prev)
+	if delta.TCP.SegmentsSent != 15 {
+		t.Fatalf("SegmentsSent = %d, want 15", delta.TCP.SegmentsSent)
+	}
+	if delta.TCP.CurrentEstablished != 2 {
+		t.Fatalf("CurrentEstablished = %d, want gauge 2", delta.TCP.CurrentEstablished)
+	}
+	if delta.Device.PacketsRead != 4 || delta.UDP.PacketsSent != 1 {
+		t.Fatalf("unexpected delta %+v", delta)
+	}
+	if cur.TCP.SegmentsSent != 25 {
+		t.Fatal("Sub modified the receiver")
+	}
+}
+
+// setStats sets every counter and gauge of v to n.
+func setStats(v reflect.Value, n uint64) {
+	for i := 0; i < v.NumField(); i++ {
+		switch f := v.Field(i); f.Kind() {
+		case reflect.Struct:
+			setStats(f, n)
+		case reflect.Uint64:
+			f.SetUint(n)
+		}
+	}
+}
+
+// checkStats calls check with the path and the value of every field of v.
+func checkStats(t *testing.T, v reflect.Value, path string, check func(path string, field reflect.StructField, n uint64)) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		switch f := v.Field(i); f.Kind() {
+		case reflect.Struct:
+			checkStats(t, f, path+field.Name+".", check)
+		case reflect.Uint64:
+			check(path+field.Name, field, f.Uint())
+		}
+	}
+}
+
+func TestStatsSubAllFields(t *testing.T) {
+	var prev, cur Stats
+	setStats(reflect.ValueOf(&prev).Elem(), 3)
+	setStats(reflect.ValueOf(&cur).Elem(), 10)
+
+	gauges := 0
+	checkStats(t, reflect.ValueOf(cur.Sub(prev)), "", func(path string, field reflect.StructField, n uint64) {
+		want := uint64(7)
+		if field.Tag.Get("stats") == "gauge" {
+			want = 10
+			gauges++
+		}
+		if n != want {
+			t.Errorf("%s = %d, want %d", path, n, want)
+		}
+	})
+	if gauges != 2 {
+		t.Errorf("%d gauges, want CurrentEstablished and CurrentConnected", gauges)
+	}
+}
+
+func TestStatsCountTraffic(t *testing.T) {
+	ns, client := startTestNetstack(t)
+	ln, err := ns.ListenTCP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+
+	before := ns.Stats()
+	httpGet(t, client, "http://1.1.1.1/")
+	delta := ns.Stats().Sub(before)
+
+	if delta.Device.PacketsRead == 0 || delta.Device.PacketsWritten == 0 {
+		t.Errorf("the device counted no packets: %+v", delta.Device)
+	}
+	if delta.IP.PacketsReceived < delta.Device.PacketsRead || delta.IP.PacketsSent == 0 {
+		t.Errorf("unexpected ip counters %+v", delta.IP)
+	}
+	if delta.TCP.SegmentsSent == 0 || delta.TCP.ValidSegmentsReceived == 0 {
+		t.Errorf("unexpected tcp counters %+v", delta.TCP)
+	}
+	if delta.TCP.CurrentEstablished == 0 {
+		t.Errorf("the keep-alive connection is not established: %+v", delta.TCP)
+	}
+}
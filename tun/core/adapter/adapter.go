@@ -2,6 +2,7 @@ package adapter
 
 import (
 	"net"
+	"time"
 
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 )
@@ -12,6 +13,37 @@ type TCPConn interface {
 
 	// ID returns the transport endpoint id of TCPConn.
 	ID() *stack.TransportEndpointID
+
+	// CloseRead shuts down the reading side of TCPConn.
+	CloseRead() error
+
+	// CloseWrite shuts down the writing side of TCPConn, the peer
+	// receives FIN.
+	CloseWrite() error
+
+	// Abort closes TCPConn with RST instead of FIN, as if SO_LINGER
+	// is set to zero.
+	Abort() error
+
+	// SetNoDelay controls whether to disable Nagle's algorithm
+	// (TCP_NODELAY).
+	SetNoDelay(noDelay bool) error
+
+	// SetKeepAlive enables or disables the TCP keep-alive probes.
+	SetKeepAlive(keepalive bool) error
+
+	// SetKeepAlivePeriod sets the idle time before the first keep-alive
+	// probe and the interval between probes.
+	SetKeepAlivePeriod(d time.Duration) error
+
+	// SetReadBuffer sets the size of the receive buffer.
+	SetReadBuffer(bytes int) error
+
+	// SetWriteBuffer sets the size of the send buffer.
+	SetWriteBuffer(bytes int) error
+
+	// TCPInfo returns the live state of TCPConn.
+	TCPInfo() (TCPInfo, error)
 }
 
 // UDPConn implements net.Conn and net.PacketConn.
@@ -0,0 +1,34 @@
+package adapter
+
+import "gvisor.dev/gvisor/pkg/tcpip/stack"
+
+// ErrorKind identifies where an error was reported.
+type ErrorKind string
+
+const (
+	// ErrorCreateEndpoint is the failure to create the endpoint of a
+	// forwarded connection, the connection is dropped.
+	ErrorCreateEndpoint ErrorKind = "create_endpoint"
+	// ErrorSocketOptions is the failure to set the default socket
+	// options of a forwarded TCP connection.
+	ErrorSocketOptions ErrorKind = "socket_options"
+	// ErrorReadPacket is the failure to read packets from a device, the
+	// device stops reading.
+	ErrorReadPacket ErrorKind = "read_packet"
+	// ErrorWritePacket is the failure to write a packet to a device, the
+	// packet is dropped.
+	ErrorWritePacket ErrorKind = "write_packet"
+	// ErrorHandlerPanic is a panic recovered in the connection handler.
+	ErrorHandlerPanic ErrorKind = "handler_panic"
+	// ErrorDNS is the failure to serve the hijacked DNS queries.
+	ErrorDNS ErrorKind = "dns"
+	// ErrorAccessLog is the failure to write the access log.
+	ErrorAccessLog ErrorKind = "access_log"
+	// ErrorProxy is the failure of an outbound proxy to relay a
+	// connection, the connection is closed.
+	ErrorProxy ErrorKind = "proxy"
+)
+
+// ErrorHandler reports the error of the connection identified by id, id is
+// nil if the error isn't specific to a connection.
+type ErrorHandler func(kind ErrorKind, id *stack.TransportEndpointID, err error)
@@ -0,0 +1,41 @@
+package adapter
+
+import "time"
+
+// TCPInfo is the live state of a TCP connection, like TCP_INFO on Linux.
+type TCPInfo struct {
+	// State is the TCP state, e.g. ESTABLISHED.
+	State string
+	// CongestionState is the congestion control state of the sender,
+	// e.g. Open or Recovery.
+	CongestionState string
+
+	// RTT is the smoothed round trip time and RTTVar is its variation.
+	RTT    time.Duration
+	RTTVar time.Duration
+	// RTO is the retransmission timeout.
+	RTO time.Duration
+
+	// CongestionWindow is the congestion window in packets.
+	CongestionWindow uint32
+	// SlowStartThreshold is the threshold between slow start and
+	// congestion avoidance.
+	SlowStartThreshold uint32
+	// ReorderSeen is whether reordering was seen.
+	ReorderSeen bool
+
+	// SegmentsSent and SegmentsReceived are the numbers of segments.
+	SegmentsSent     uint64
+	SegmentsReceived uint64
+	// Retransmits is the number of retransmitted segments, FastRetransmits
+	// and Timeouts are the retransmits of fast recovery and the RTO
+	// expirations among them.
+	Retransmits     uint64
+	FastRetransmits uint64
+	Timeouts        uint64
+
+	// SendQueueSize is the number of bytes written but not acknowledged
+	// yet, ReceiveQueueSize is the number of bytes received but not read.
+	SendQueueSize    int
+	ReceiveQueueSize int
+}
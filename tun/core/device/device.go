@@ -1,6 +1,7 @@
 package device
 
 import (
+	"github.com/josexy/netstackgo/tun/core/adapter"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 )
 
@@ -14,4 +15,27 @@ type Device interface {
 
 	// Name returns the current name of the device.
 	Name() string
+
+	// Stats returns the packet counters of the device.
+	Stats() Stats
+
+	// SetErrorHandler sets the handler of the packet read and write
+	// errors, it must be called before the device is attached.
+	SetErrorHandler(onError adapter.ErrorHandler)
+}
+
+// Stats is the packet counters of a device.
+type Stats struct {
+	// PacketsRead is the number of packets read from the device.
+	PacketsRead uint64
+	// PacketsWritten is the number of packets written to the device.
+	PacketsWritten uint64
+	// OversizeDrops is the number of read packets dropped for exceeding MTU.
+	OversizeDrops uint64
+	// QueueDrops is the number of outbound packets dropped because the
+	// outbound queue is full.
+	QueueDrops uint64
+	// WriteErrors is the number of packets in the failed writes to the
+	// device.
+	WriteErrors uint64
 }
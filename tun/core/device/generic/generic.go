@@ -0,0 +1,114 @@
+// Package generic provides the devices reading and writing packets over an
+// io.ReadWriteCloser or a net.PacketConn, so that the netstack is driven by
+// another process or a remote peer instead of a kernel TUN device.
+package generic
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/josexy/netstackgo/tun/core/device/iobased"
+)
+
+// frameHeaderLen is the size of the big-endian length prefix of a framed
+// packet.
+const frameHeaderLen = 2
+
+// Device is a device over an io.ReadWriteCloser, it's closed by Close.
+type Device struct {
+	*iobased.Endpoint
+
+	rwc  io.ReadWriteCloser
+	name string
+}
+
+// New returns the device over rwc, each read of which returns a single
+// packet and each write of which writes a single packet, e.g. a Unix
+// seqpacket socket or a datagram pipe.
+func New(name string, rwc io.ReadWriteCloser, mtu uint32) (*Device, error) {
+	return newDevice(name, rwc, mtu, 0)
+}
+
+// NewFramed returns the device over the byte stream rwc, e.g. a TCP
+// connection or the stdio of a process, each packet of which is prefixed
+// by its length in 2 bytes big-endian.
+func NewFramed(name string, rwc io.ReadWriteCloser, mtu uint32) (*Device, error) {
+	if mtu > 1<<16-1 {
+		return nil, fmt.Errorf("MTU %d exceeds the maximum frame size", mtu)
+	}
+	return newDevice(name, &framedReadWriter{ReadWriteCloser: rwc}, mtu, frameHeaderLen)
+}
+
+// DialUnix returns the device over the Unix seqpacket socket connected to
+// the address path.
+func DialUnix(name, path string, mtu uint32) (*Device, error) {
+	conn, err := net.DialUnix("unixpacket", nil, &net.UnixAddr{Name: path, Net: "unixpacket"})
+	if err != nil {
+		return nil, err
+	}
+	d, err := New(name, conn, mtu)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+func newDevice(name string, rwc io.ReadWriteCloser, mtu uint32, offset int) (*Device, error) {
+	if rwc == nil {
+		return nil, errors.New("RWC interface is nil")
+	}
+	d := &Device{rwc: rwc, name: name}
+	ep, err := iobased.New(rwc, mtu, offset)
+	if err != nil {
+		return nil, fmt.Errorf("create endpoint: %w", err)
+	}
+	d.Endpoint = ep
+	return d, nil
+}
+
+func (d *Device) Name() string {
+	return d.name
+}
+
+func (d *Device) Close() error {
+	defer d.Endpoint.Close()
+	return d.rwc.Close()
+}
+
+// framedReadWriter reads and writes the length-prefixed packets, the
+// length is read into and written from the offset bytes in front of a
+// packet.
+type framedReadWriter struct {
+	io.ReadWriteCloser
+}
+
+// Read reads a packet after the length prefix, the part of the packet
+// exceeding packet is discarded, and the size of the whole packet is
+// returned so that it's dropped as oversize.
+func (rw *framedReadWriter) Read(packet []byte) (int, error) {
+	if _, err := io.ReadFull(rw.ReadWriteCloser, packet[:frameHeaderLen]); err != nil {
+		return 0, err
+	}
+	size := int(binary.BigEndian.Uint16(packet))
+	buf := packet[frameHeaderLen:]
+	if size > len(buf) {
+		if _, err := io.CopyN(io.Discard, rw.ReadWriteCloser, int64(size)); err != nil {
+			return 0, err
+		}
+		return size, nil
+	}
+	if _, err := io.ReadFull(rw.ReadWriteCloser, buf[:size]); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// Write writes a packet with its length prefix in a single write.
+func (rw *framedReadWriter) Write(packet []byte) (int, error) {
+	binary.BigEndian.PutUint16(packet, uint16(len(packet)-frameHeaderLen))
+	return rw.ReadWriteCloser.Write(packet)
+}
@@ -0,0 +1,309 @@
+package generic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/josexy/netstackgo/tun/core/device"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+type dispatcher chan []byte
+
+func (d dispatcher) DeliverNetworkPacket(_ tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	d <- pkt.ToView().AsSlice()
+}
+
+func (dispatcher) DeliverLinkPacket(tcpip.NetworkProtocolNumber, *stack.PacketBuffer) {}
+
+func newIPv4Packet(size int) []byte {
+	packet := make([]byte, size)
+	packet[0] = 0x45
+	for i := 1; i < size; i++ {
+		packet[i] = byte(i)
+	}
+	return packet
+}
+
+func receive(t *testing.T, d dispatcher) []byte {
+	t.Helper()
+	select {
+	case packet := <-d:
+		return packet
+	case <-time.After(5 * time.Second):
+		t.Fatal("no packet read")
+		return nil
+	}
+}
+
+func writePacket(t *testing.T, dev device.Device, packet []byte) {
+	t.Helper()
+	var pkts stack.PacketBufferList
+	pkts.PushBack(stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: buffer.MakeWithData(packet),
+	}))
+	if n, err := dev.WritePackets(pkts); err != nil || n != 1 {
+		t.Fatalf("WritePackets = %d, %v", n, err)
+	}
+	pkts.DecRef()
+}
+
+func TestFramed(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	dev, err := NewFramed("pipe", local, 1500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := make(dispatcher, 1)
+	dev.Attach(d)
+
+	oversize, packet := newIPv4Packet(1600), newIPv4Packet(100)
+	go func() {
+		for _, p := range [][]byte{oversize, packet} {
+			remote.Write(binary.BigEndian.AppendUint16(nil, uint16(len(p))))
+			remote.Write(p)
+		}
+	}()
+	if got := receive(t, d); !bytes.Equal(got, packet) {
+		t.Errorf("read packet %x, want %x", got, packet)
+	}
+	if stats := dev.Stats(); stats.OversizeDrops != 1 {
+		t.Errorf("OversizeDrops = %d, want 1", stats.OversizeDrops)
+	}
+
+	writePacket(t, dev, packet)
+	buf := make([]byte, 2+len(packet))
+	if _, err = remote.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := append(binary.BigEndian.AppendUint16(nil, uint16(len(packet))), packet...); !bytes.Equal(buf, want) {
+		t.Errorf("written frame %x, want %x", buf, want)
+	}
+
+	if err = dev.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dev.Wait()
+}
+
+func TestDialUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dev.sock")
+	ln, err := net.ListenUnix("unixpacket", &net.UnixAddr{Name: path, Net: "unixpacket"})
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+
+	dev, err := DialUnix("unix", path, 1500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	d := make(dispatcher, 2)
+	dev.Attach(d)
+
+	// the message boundaries are kept
+	packets := [][]byte{newIPv4Packet(100), newIPv4Packet(200)}
+	for _, packet := range packets {
+		if _, err = remote.Write(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, packet := range packets {
+		if got := receive(t, d); !bytes.Equal(got, packet) {
+			t.Errorf("read packet %x, want %x", got, packet)
+		}
+	}
+
+	writePacket(t, dev, packets[0])
+	buf := make([]byte, 1500)
+	n, err := remote.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], packets[0]) {
+		t.Errorf("written packet %x, want %x", buf[:n], packets[0])
+	}
+
+	if err = dev.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dev.Wait()
+}
+
+func TestPacketConn(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+
+	dev, err := NewPacketConn("udp", conn, nil, 1500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := make(dispatcher, 1)
+	dev.Attach(d)
+
+	// no packet can be written before the peer is learned
+	if _, err = dev.Write(newIPv4Packet(100)); err != errNoPeer {
+		t.Errorf("Write = %v, want errNoPeer", err)
+	}
+
+	packet := newIPv4Packet(100)
+	if _, err = remote.WriteTo(packet, conn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, d); !bytes.Equal(got, packet) {
+		t.Errorf("read packet %x, want %x", got, packet)
+	}
+	if peer := dev.Peer(); !addrEqual(peer, remote.LocalAddr()) {
+		t.Errorf("Peer = %v, want %v", peer, remote.LocalAddr())
+	}
+
+	writePacket(t, dev, packet)
+	remote.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1500)
+	n, err := remote.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], packet) {
+		t.Errorf("written packet %x, want %x", buf[:n], packet)
+	}
+
+	// the learned peer is kept
+	stranger, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stranger.Close()
+	ignored, packet := newIPv4Packet(50), newIPv4Packet(80)
+	if _, err = stranger.WriteTo(ignored, conn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = remote.WriteTo(packet, conn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, d); !bytes.Equal(got, packet) {
+		t.Errorf("read packet %x, want %x", got, packet)
+	}
+	if peer := dev.Peer(); !addrEqual(peer, remote.LocalAddr()) {
+		t.Errorf("Peer = %v, want %v", peer, remote.LocalAddr())
+	}
+
+	if err = dev.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dev.Wait()
+}
+
+func TestPacketConnRoaming(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	roamed, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer roamed.Close()
+
+	dev, err := NewPacketConn("udp", conn, peer.LocalAddr(), 1500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev.SetRoaming(true)
+	d := make(dispatcher, 1)
+	dev.Attach(d)
+
+	packet := newIPv4Packet(100)
+	if _, err = roamed.WriteTo(packet, conn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, d); !bytes.Equal(got, packet) {
+		t.Errorf("read packet %x, want %x", got, packet)
+	}
+	if !addrEqual(dev.Peer(), roamed.LocalAddr()) {
+		t.Errorf("Peer = %v, want %v", dev.Peer(), roamed.LocalAddr())
+	}
+
+	writePacket(t, dev, packet)
+	roamed.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1500)
+	n, err := roamed.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], packet) {
+		t.Errorf("written packet %x, want %x", buf[:n], packet)
+	}
+
+	if err = dev.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dev.Wait()
+}
+
+func TestPacketConnFixedPeer(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	stranger, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stranger.Close()
+
+	dev, err := NewPacketConn("udp", conn, peer.LocalAddr(), 1500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := make(dispatcher, 2)
+	dev.Attach(d)
+
+	ignored, packet := newIPv4Packet(50), newIPv4Packet(100)
+	if _, err = stranger.WriteTo(ignored, conn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = peer.WriteTo(packet, conn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, d); !bytes.Equal(got, packet) {
+		t.Errorf("read packet %x, want %x", got, packet)
+	}
+	if !addrEqual(dev.Peer(), peer.LocalAddr()) {
+		t.Errorf("Peer = %v, want %v", dev.Peer(), peer.LocalAddr())
+	}
+
+	if err = dev.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dev.Wait()
+}
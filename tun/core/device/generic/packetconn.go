@@ -0,0 +1,115 @@
+package generic
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	"github.com/josexy/netstackgo/tun/core/device/iobased"
+)
+
+var errNoPeer = errors.New("no peer address to write packets to")
+
+// PacketConn is a device over a net.PacketConn carrying a single packet
+// per datagram, it's closed by Close.
+type PacketConn struct {
+	*iobased.Endpoint
+
+	conn net.PacketConn
+	name string
+
+	// peer is the address the packets are written to.
+	peer atomic.Pointer[net.Addr]
+	// roaming is whether peer follows the source of the read packets.
+	roaming atomic.Bool
+}
+
+// NewPacketConn returns the device over conn. The packets are written to
+// peer, or to the source address of the first read packet if peer is nil;
+// the packets from other addresses are ignored unless roaming is enabled
+// with SetRoaming.
+func NewPacketConn(name string, conn net.PacketConn, peer net.Addr, mtu uint32) (*PacketConn, error) {
+	if conn == nil {
+		return nil, errors.New("conn is nil")
+	}
+	d := &PacketConn{conn: conn, name: name}
+	if peer != nil {
+		d.peer.Store(&peer)
+	}
+	ep, err := iobased.New(d, mtu, 0)
+	if err != nil {
+		return nil, fmt.Errorf("create endpoint: %w", err)
+	}
+	d.Endpoint = ep
+	return d, nil
+}
+
+// SetRoaming sets whether the peer follows the source address of the read
+// packets. Roaming lets the peer change its address, but anyone able to send
+// a datagram to conn then takes over the device: its packets are injected
+// and all the following packets are written to it.
+func (d *PacketConn) SetRoaming(roaming bool) {
+	d.roaming.Store(roaming)
+}
+
+// Read reads a packet, the packets from other than the peer are ignored
+// unless roaming is enabled.
+func (d *PacketConn) Read(packet []byte) (int, error) {
+	n, addr, err := d.conn.ReadFrom(packet)
+	if err != nil {
+		return 0, err
+	}
+	peer := d.peer.Load()
+	switch {
+	case peer == nil:
+		// lock onto the first peer
+		if !d.peer.CompareAndSwap(nil, &addr) && !addrEqual(addr, *d.peer.Load()) {
+			return 0, nil
+		}
+	case addrEqual(addr, *peer):
+	case d.roaming.Load():
+		d.peer.Store(&addr)
+	default:
+		return 0, nil
+	}
+	return n, nil
+}
+
+func (d *PacketConn) Write(packet []byte) (int, error) {
+	peer := d.peer.Load()
+	if peer == nil {
+		return 0, errNoPeer
+	}
+	return d.conn.WriteTo(packet, *peer)
+}
+
+// Peer returns the address the packets are written to, or nil if no packet
+// is read yet.
+func (d *PacketConn) Peer() net.Addr {
+	if peer := d.peer.Load(); peer != nil {
+		return *peer
+	}
+	return nil
+}
+
+func (d *PacketConn) Name() string {
+	return d.name
+}
+
+func (d *PacketConn) Close() error {
+	defer d.Endpoint.Close()
+	return d.conn.Close()
+}
+
+// addrEqual reports whether a and b are the same address, it doesn't
+// allocate for the UDP addresses.
+func addrEqual(a, b net.Addr) bool {
+	if ua, ok := a.(*net.UDPAddr); ok {
+		if ub, ok := b.(*net.UDPAddr); ok {
+			pa, pb := ua.AddrPort(), ub.AddrPort()
+			return pa.Addr().Unmap() == pb.Addr().Unmap() && pa.Port() == pb.Port()
+		}
+	}
+	return a.Network() == b.Network() && a.String() == b.String()
+}
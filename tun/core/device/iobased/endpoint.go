@@ -5,9 +5,16 @@ package iobased
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"net"
+	"os"
+	"slices"
 	"sync"
+	"sync/atomic"
 
+	"github.com/josexy/netstackgo/tun/core/adapter"
+	"github.com/josexy/netstackgo/tun/core/device"
 	"gvisor.dev/gvisor/pkg/buffer"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
@@ -16,17 +23,83 @@ import (
 )
 
 const (
-	// Queue length for outbound packet, arriving for read. Overflow
-	// causes packet drops.
+	// Queue length for outbound packet of each queue, arriving for read.
+	// Overflow causes packet drops.
 	defaultOutQueueLen = 1 << 10
 )
 
+// BatchReadWriter reads and writes multiple packets per call, e.g. the
+// wireguard-go tun.Device. Each buffer has offset bytes in front of the
+// packet, which are reserved for the device.
+type BatchReadWriter interface {
+	// BatchSize returns the preferred number of buffers per call.
+	BatchSize() int
+
+	// ReadBatch reads packets into bufs[i][offset:], and sets sizes[i] to
+	// the size of the packet. It returns the number of packets read.
+	ReadBatch(bufs [][]byte, sizes []int, offset int) (int, error)
+
+	// WriteBatch writes the packets bufs[i][offset:].
+	WriteBatch(bufs [][]byte, offset int) (int, error)
+}
+
+// Offloader is implemented by the BatchReadWriter offloading the TCP/UDP
+// segmentation to the kernel, e.g. a Linux TUN device with IFF_VNET_HDR. The
+// segments written in a batch may be coalesced into a packet of at most
+// OffloadSize bytes, given the capacity of the buffers.
+type Offloader interface {
+	OffloadSize() int
+}
+
+// singleReadWriter is the BatchReadWriter of an io.ReadWriter reading and
+// writing a packet per call.
+type singleReadWriter struct {
+	io.ReadWriter
+}
+
+func (singleReadWriter) BatchSize() int { return 1 }
+
+func (rw singleReadWriter) ReadBatch(bufs [][]byte, sizes []int, offset int) (n int, err error) {
+	if sizes[0], err = rw.Read(bufs[0]); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+func (rw singleReadWriter) WriteBatch(bufs [][]byte, offset int) (int, error) {
+	for i, buf := range bufs {
+		if _, err := rw.Write(buf); err != nil {
+			return i, err
+		}
+	}
+	return len(bufs), nil
+}
+
+// queue is a queue of the device, each is read and written by its own
+// goroutines.
+type queue struct {
+	// rw is the io.ReadWriter for reading and writing packets, it's
+	// BatchReadWriter if implemented by the io.ReadWriter.
+	rw BatchReadWriter
+
+	// batchSize is the maximum number of packets per read and write.
+	batchSize int
+
+	// out is the outbound packets to be written to rw. Overflow causes
+	// packet drops.
+	out chan *stack.PacketBuffer
+}
+
 // Endpoint implements the interface of stack.LinkEndpoint from io.ReadWriter.
 type Endpoint struct {
 	*channel.Endpoint
 
-	// rw is the io.ReadWriter for reading and writing packets.
-	rw io.ReadWriter
+	// queues are the queues of the device, the outbound packets of a
+	// flow are always written to the same queue to keep their order.
+	queues []*queue
+
+	// closed is set by Close, the outbound packets are dropped then.
+	closed atomic.Bool
 
 	// mtu (maximum transmission unit) is the maximum size of a packet.
 	mtu uint32
@@ -34,20 +107,44 @@ type Endpoint struct {
 	// offset can be useful when perform TUN device I/O with TUN_PI enabled.
 	offset int
 
+	// writeSize is the capacity of the write buffers, which is greater
+	// than offset+mtu if the written packets can be coalesced.
+	writeSize int
+
 	// once is used to perform the init action once when attaching.
 	once sync.Once
 
 	// wg keeps track of running goroutines.
 	wg sync.WaitGroup
+
+	// onError reports the packet read and write errors if set.
+	onError adapter.ErrorHandler
+
+	// packet counters reported by Stats.
+	packetsRead    atomic.Uint64
+	packetsWritten atomic.Uint64
+	oversizeDrops  atomic.Uint64
+	queueDrops     atomic.Uint64
+	writeErrors    atomic.Uint64
 }
 
-// New returns stack.LinkEndpoint(.*Endpoint) and error.
+// New returns stack.LinkEndpoint(.*Endpoint) and error. The packets are read
+// and written in batches if rw implements BatchReadWriter, otherwise rw
+// reads a packet into the buffer after offset bytes and returns its size,
+// and writes a packet with offset bytes in front of it.
 func New(rw io.ReadWriter, mtu uint32, offset int) (*Endpoint, error) {
+	return NewMultiQueue([]io.ReadWriter{rw}, mtu, offset)
+}
+
+// NewMultiQueue returns the stack.LinkEndpoint(.*Endpoint) of a device with
+// multiple queues, e.g. a Linux TUN device with IFF_MULTI_QUEUE. Each queue
+// is read and written in parallel like the rw of New.
+func NewMultiQueue(rws []io.ReadWriter, mtu uint32, offset int) (*Endpoint, error) {
 	if mtu == 0 {
 		return nil, errors.New("MTU size is zero")
 	}
 
-	if rw == nil {
+	if len(rws) == 0 || slices.Contains(rws, nil) {
 		return nil, errors.New("RW interface is nil")
 	}
 
@@ -55,12 +152,31 @@ func New(rw io.ReadWriter, mtu uint32, offset int) (*Endpoint, error) {
 		return nil, errors.New("offset must be non-negative")
 	}
 
-	return &Endpoint{
-		Endpoint: channel.New(defaultOutQueueLen, mtu, ""),
-		rw:       rw,
-		mtu:      mtu,
-		offset:   offset,
-	}, nil
+	e := &Endpoint{
+		Endpoint:  channel.New(0, mtu, ""),
+		mtu:       mtu,
+		offset:    offset,
+		writeSize: offset + int(mtu),
+	}
+	for _, rw := range rws {
+		brw, ok := rw.(BatchReadWriter)
+		if !ok {
+			brw = singleReadWriter{rw}
+		}
+		e.queues = append(e.queues, &queue{
+			rw:        brw,
+			batchSize: max(brw.BatchSize(), 1),
+			out:       make(chan *stack.PacketBuffer, defaultOutQueueLen),
+		})
+	}
+
+	if o, ok := rws[0].(Offloader); ok && o.OffloadSize() > 0 {
+		// TCP segments are then sent in batches by gVisor, so that the
+		// device can coalesce them.
+		e.SupportedGSOKind = stack.GvisorGSOSupported
+		e.writeSize = max(e.writeSize, offset+o.OffloadSize())
+	}
+	return e, nil
 }
 
 // Attach launches the goroutine that reads packets from io.Reader and
@@ -68,86 +184,264 @@ func New(rw io.ReadWriter, mtu uint32, offset int) (*Endpoint, error) {
 func (e *Endpoint) Attach(dispatcher stack.NetworkDispatcher) {
 	e.Endpoint.Attach(dispatcher)
 	e.once.Do(func() {
-		ctx, cancel := context.WithCancel(context.Background())
-		e.wg.Add(2)
-		go func() {
-			e.outboundLoop(ctx)
-			e.wg.Done()
-		}()
-		go func() {
-			e.dispatchLoop(cancel)
-			e.wg.Done()
-		}()
+		for _, q := range e.queues {
+			ctx, cancel := context.WithCancel(context.Background())
+			e.wg.Add(2)
+			go func() {
+				e.outboundLoop(ctx, q)
+				e.wg.Done()
+			}()
+			go func() {
+				e.dispatchLoop(cancel, q)
+				e.wg.Done()
+			}()
+		}
 	})
 }
 
+// Close drops the outbound packets afterwards.
+func (e *Endpoint) Close() {
+	e.closed.Store(true)
+	e.Endpoint.Close()
+}
+
 func (e *Endpoint) Wait() {
 	e.wg.Wait()
 }
 
+// SetErrorHandler sets the handler of the packet read and write errors.
+func (e *Endpoint) SetErrorHandler(onError adapter.ErrorHandler) {
+	e.onError = onError
+}
+
+func (e *Endpoint) reportError(kind adapter.ErrorKind, err error) {
+	if e.onError != nil {
+		e.onError(kind, nil, err)
+	}
+}
+
+// WritePackets queues outbound packets to the queue of their flows, and
+// counts the packets dropped because the queue is full.
+func (e *Endpoint) WritePackets(pkts stack.PacketBufferList) (int, tcpip.Error) {
+	if e.closed.Load() {
+		return 0, &tcpip.ErrClosedForSend{}
+	}
+	n := 0
+	for _, pkt := range pkts.AsSlice() {
+		q := e.queues[0]
+		if len(e.queues) > 1 {
+			q = e.queues[flowHash(pkt)%uint32(len(e.queues))]
+		}
+		select {
+		case q.out <- pkt.IncRef():
+			n++
+		default:
+			pkt.DecRef()
+			e.queueDrops.Add(1)
+		}
+	}
+	return n, nil
+}
+
+// Stats returns the packet counters of the endpoint.
+func (e *Endpoint) Stats() device.Stats {
+	return device.Stats{
+		PacketsRead:    e.packetsRead.Load(),
+		PacketsWritten: e.packetsWritten.Load(),
+		OversizeDrops:  e.oversizeDrops.Load(),
+		QueueDrops:     e.queueDrops.Load(),
+		WriteErrors:    e.writeErrors.Load(),
+	}
+}
+
 // dispatchLoop dispatches packets to upper layer.
-func (e *Endpoint) dispatchLoop(cancel context.CancelFunc) {
+func (e *Endpoint) dispatchLoop(cancel context.CancelFunc, q *queue) {
 	// Call cancel() to ensure (*Endpoint).outboundLoop(context.Context) exits
 	// gracefully after (*Endpoint).dispatchLoop(context.CancelFunc) returns.
 	defer cancel()
 
 	offset, mtu := e.offset, int(e.mtu)
 
+	// The packets are read into the views from the gVisor buffer pool,
+	// which are then owned by the packet buffers without copying. Only
+	// the views of the delivered packets are replaced.
+	views := make([]*buffer.View, q.batchSize)
+	bufs := make([][]byte, q.batchSize)
+	sizes := make([]int, q.batchSize)
+	defer func() {
+		for _, v := range views {
+			if v != nil {
+				v.Release()
+			}
+		}
+	}()
+
 	for {
-		data := make([]byte, offset+mtu)
+		for i, v := range views {
+			if v == nil {
+				views[i] = buffer.NewViewSize(offset + mtu)
+				bufs[i] = views[i].AsSlice()
+			}
+		}
 
-		n, err := e.rw.Read(data)
+		n, err := q.rw.ReadBatch(bufs, sizes, offset)
 		if err != nil {
+			// the device being closed is not an error
+			if !errors.Is(err, os.ErrClosed) && !errors.Is(err, net.ErrClosed) {
+				e.reportError(adapter.ErrorReadPacket, err)
+			}
 			break
 		}
 
-		if n == 0 || n > mtu {
-			continue
-		}
+		for i := 0; i < n; i++ {
+			if sizes[i] == 0 {
+				continue
+			}
+			e.packetsRead.Add(1)
+
+			if sizes[i] > mtu {
+				e.oversizeDrops.Add(1)
+				continue
+			}
 
-		if !e.IsAttached() {
-			continue /* unattached, drop packet */
+			v := views[i]
+			views[i] = nil
+			v.TrimFront(offset)
+			v.CapLength(sizes[i])
+			e.deliverPacket(v)
 		}
+	}
+}
 
-		pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
-			Payload: buffer.MakeWithData(data[offset : offset+n]),
-		})
+// deliverPacket injects an inbound packet into the stack, it takes the
+// ownership of v.
+func (e *Endpoint) deliverPacket(v *buffer.View) {
+	if !e.IsAttached() {
+		v.Release()
+		return /* unattached, drop packet */
+	}
 
-		switch header.IPVersion(data[offset:]) {
-		case header.IPv4Version:
-			e.InjectInbound(header.IPv4ProtocolNumber, pkt)
-		case header.IPv6Version:
-			e.InjectInbound(header.IPv6ProtocolNumber, pkt)
-		}
-		pkt.DecRef()
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: buffer.MakeWithView(v),
+	})
+
+	switch header.IPVersion(v.AsSlice()) {
+	case header.IPv4Version:
+		e.InjectInbound(header.IPv4ProtocolNumber, pkt)
+	case header.IPv6Version:
+		e.InjectInbound(header.IPv6ProtocolNumber, pkt)
 	}
+	pkt.DecRef()
 }
 
-// outboundLoop reads outbound packets from channel, and then it calls
-// writePacket to send those packets back to lower layer.
-func (e *Endpoint) outboundLoop(ctx context.Context) {
+// outboundLoop reads outbound packets from the queue, and then it calls
+// writePackets to send those packets back to lower layer. The packets
+// queued meanwhile are drained to be written in the same batch.
+func (e *Endpoint) outboundLoop(ctx context.Context, q *queue) {
+	defer func() {
+		for {
+			select {
+			case pkt := <-q.out:
+				pkt.DecRef()
+			default:
+				return
+			}
+		}
+	}()
+
+	pkts := make([]*stack.PacketBuffer, 0, q.batchSize)
+	bufs := make([][]byte, q.batchSize)
 	for {
-		pkt := e.ReadContext(ctx)
-		if pkt == nil {
-			break
+		select {
+		case pkt := <-q.out:
+			pkts = append(pkts[:0], pkt)
+		case <-ctx.Done():
+			return
+		}
+	drain:
+		for len(pkts) < q.batchSize {
+			select {
+			case pkt := <-q.out:
+				pkts = append(pkts, pkt)
+			default:
+				break drain
+			}
 		}
-		e.writePacket(pkt)
+		e.writePackets(q, pkts, bufs)
 	}
 }
 
-// writePacket writes outbound packets to the io.Writer.
-func (e *Endpoint) writePacket(pkt *stack.PacketBuffer) tcpip.Error {
-	defer pkt.DecRef()
-	buf := pkt.ToBuffer()
-	defer buf.Release()
-	if e.offset != 0 {
-		v := buffer.NewViewWithData(make([]byte, e.offset))
-		_ = buf.Prepend(v)
+// writePackets writes outbound packets to the io.Writer. The packet data is
+// copied once into bufs, which are reused between the calls, instead of
+// being flattened into new slices.
+func (e *Endpoint) writePackets(q *queue, pkts []*stack.PacketBuffer, bufs [][]byte) tcpip.Error {
+	for i, pkt := range pkts {
+		size := e.offset + pkt.Size()
+		if cap(bufs[i]) < size {
+			bufs[i] = make([]byte, max(size, e.writeSize))
+		}
+		buf := bufs[i][:size]
+		clear(buf[:e.offset])
+		copyPacket(buf[e.offset:], pkt)
+		bufs[i] = buf
+		pkt.DecRef()
 	}
 
-	// the written size was ignored
-	if _, err := e.rw.Write(buf.Flatten()); err != nil {
+	if _, err := q.rw.WriteBatch(bufs[:len(pkts)], e.offset); err != nil {
+		e.writeErrors.Add(uint64(len(pkts)))
+		e.reportError(adapter.ErrorWritePacket, fmt.Errorf("write packets: %w", err))
 		return &tcpip.ErrInvalidEndpointState{}
 	}
+	e.packetsWritten.Add(uint64(len(pkts)))
 	return nil
 }
+
+// copyPacket copies the data of pkt into dst without allocating.
+func copyPacket(dst []byte, pkt *stack.PacketBuffer) int {
+	vl, offset := pkt.AsViewList()
+	n := 0
+	for v := vl.Front(); v != nil; v = v.Next() {
+		b := v.AsSlice()
+		if offset >= len(b) {
+			offset -= len(b)
+			continue
+		}
+		n += copy(dst[n:], b[offset:])
+		offset = 0
+	}
+	return n
+}
+
+// flowHash returns the FNV-1a hash of the addresses, the transport protocol
+// and the ports of an outbound packet, which selects its queue.
+func flowHash(pkt *stack.PacketBuffer) uint32 {
+	const prime = 16777619
+	h := uint32(2166136261)
+	hash := func(b []byte) {
+		for _, c := range b {
+			h = (h ^ uint32(c)) * prime
+		}
+	}
+	switch nh := pkt.NetworkHeader().Slice(); header.IPVersion(nh) {
+	case header.IPv4Version:
+		if len(nh) < header.IPv4MinimumSize {
+			return h
+		}
+		ip := header.IPv4(nh)
+		hash(ip[12:20]) // source and destination addresses
+		hash([]byte{ip[9]})
+	case header.IPv6Version:
+		if len(nh) < header.IPv6MinimumSize {
+			return h
+		}
+		ip := header.IPv6(nh)
+		hash(ip[8:40]) // source and destination addresses
+		hash([]byte{ip[6]})
+	default:
+		return h
+	}
+	if th := pkt.TransportHeader().Slice(); len(th) >= 4 {
+		hash(th[:4]) // source and destination ports
+	}
+	return h
+}
@@ -0,0 +1,290 @@
+package iobased
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"sync"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// batchRW records the batches written, and reads nothing until closed.
+type batchRW struct {
+	mu      sync.Mutex
+	batches [][][]byte
+	written chan struct{}
+	closed  chan struct{}
+}
+
+func newBatchRW() *batchRW {
+	return &batchRW{written: make(chan struct{}, 1), closed: make(chan struct{})}
+}
+
+func (rw *batchRW) Read([]byte) (int, error)  { panic("unexpected Read") }
+func (rw *batchRW) Write([]byte) (int, error) { panic("unexpected Write") }
+func (rw *batchRW) BatchSize() int            { return 8 }
+func (rw *batchRW) ReadBatch([][]byte, []int, int) (int, error) {
+	<-rw.closed
+	return 0, os.ErrClosed
+}
+
+func (rw *batchRW) WriteBatch(bufs [][]byte, offset int) (int, error) {
+	batch := make([][]byte, len(bufs))
+	for i, buf := range bufs {
+		batch[i] = bytes.Clone(buf)
+	}
+	rw.mu.Lock()
+	rw.batches = append(rw.batches, batch)
+	rw.mu.Unlock()
+	rw.written <- struct{}{}
+	return len(bufs), nil
+}
+
+func TestOutboundBatch(t *testing.T) {
+	rw := newBatchRW()
+	ep, err := New(rw, 1500, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// queue the packets before attaching, so they are written in a batch
+	var pkts stack.PacketBufferList
+	for i := 0; i < 5; i++ {
+		pkts.PushBack(stack.NewPacketBuffer(stack.PacketBufferOptions{
+			Payload: buffer.MakeWithData([]byte{0x45, byte(i)}),
+		}))
+	}
+	if n, err := ep.WritePackets(pkts); err != nil || n != 5 {
+		t.Fatalf("WritePackets = %d, %v", n, err)
+	}
+	pkts.DecRef()
+
+	ep.Attach(nil)
+	<-rw.written
+	close(rw.closed)
+	ep.Wait()
+
+	if len(rw.batches) != 1 || len(rw.batches[0]) != 5 {
+		t.Fatalf("got batches %v, want a batch of 5 packets", rw.batches)
+	}
+	for i, buf := range rw.batches[0] {
+		if want := []byte{0, 0, 0, 0, 0x45, byte(i)}; !bytes.Equal(buf, want) {
+			t.Errorf("packet %d = %x, want %x", i, buf, want)
+		}
+	}
+	if stats := ep.Stats(); stats.PacketsWritten != 5 {
+		t.Errorf("PacketsWritten = %d, want 5", stats.PacketsWritten)
+	}
+}
+
+type offloadRW struct {
+	*batchRW
+}
+
+func (offloadRW) OffloadSize() int { return 1<<16 - 1 }
+
+func TestOffload(t *testing.T) {
+	ep, err := New(offloadRW{newBatchRW()}, 1500, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ep.SupportedGSO() != stack.GvisorGSOSupported {
+		t.Errorf("SupportedGSO = %v, want GvisorGSOSupported", ep.SupportedGSO())
+	}
+	if ep.writeSize != 10+1<<16-1 {
+		t.Errorf("writeSize = %d", ep.writeSize)
+	}
+
+	ep, _ = New(newBatchRW(), 1500, 10)
+	if ep.SupportedGSO() != stack.GSONotSupported || ep.writeSize != 1510 {
+		t.Errorf("unexpected offload without Offloader: %v, %d", ep.SupportedGSO(), ep.writeSize)
+	}
+}
+
+// packetRW reads the packet count times in batches, then the device is
+// closed. The written packets are discarded.
+type packetRW struct {
+	packet []byte
+	count  int
+}
+
+func (rw *packetRW) Read([]byte) (int, error)  { panic("unexpected Read") }
+func (rw *packetRW) Write([]byte) (int, error) { panic("unexpected Write") }
+func (rw *packetRW) BatchSize() int            { return 8 }
+
+func (rw *packetRW) ReadBatch(bufs [][]byte, sizes []int, offset int) (int, error) {
+	if rw.count == 0 {
+		return 0, os.ErrClosed
+	}
+	n := min(rw.count, len(bufs))
+	for i := 0; i < n; i++ {
+		sizes[i] = copy(bufs[i][offset:], rw.packet)
+	}
+	rw.count -= n
+	return n, nil
+}
+
+func (rw *packetRW) WriteBatch(bufs [][]byte, offset int) (int, error) {
+	return len(bufs), nil
+}
+
+type dispatcher struct {
+	packets int
+	last    []byte
+}
+
+func (d *dispatcher) DeliverNetworkPacket(_ tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	d.packets++
+	if d.last == nil {
+		d.last = pkt.ToView().AsSlice()
+	}
+}
+
+func (d *dispatcher) DeliverLinkPacket(tcpip.NetworkProtocolNumber, *stack.PacketBuffer) {}
+
+func newIPv4Packet(size int) []byte {
+	packet := make([]byte, size)
+	packet[0] = 0x45
+	for i := 1; i < size; i++ {
+		packet[i] = byte(i)
+	}
+	return packet
+}
+
+func TestDispatch(t *testing.T) {
+	packet := newIPv4Packet(100)
+	ep, err := New(&packetRW{packet: packet, count: 20}, 1500, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &dispatcher{}
+	ep.Endpoint.Attach(d)
+	_, cancel := context.WithCancel(context.Background())
+	ep.dispatchLoop(cancel, ep.queues[0])
+
+	if d.packets != 20 {
+		t.Fatalf("delivered %d packets, want 20", d.packets)
+	}
+	if !bytes.Equal(d.last, packet) {
+		t.Fatalf("delivered %x, want %x", d.last, packet)
+	}
+	if stats := ep.Stats(); stats.PacketsRead != 20 {
+		t.Fatalf("PacketsRead = %d, want 20", stats.PacketsRead)
+	}
+}
+
+func BenchmarkDispatch(b *testing.B) {
+	ep, err := New(&packetRW{packet: newIPv4Packet(1400), count: b.N}, 1500, 4)
+	if err != nil {
+		b.Fatal(err)
+	}
+	ep.Endpoint.Attach(&dispatcher{})
+	_, cancel := context.WithCancel(context.Background())
+	b.ReportAllocs()
+	b.SetBytes(1400)
+	b.ResetTimer()
+	ep.dispatchLoop(cancel, ep.queues[0])
+}
+
+func BenchmarkWritePackets(b *testing.B) {
+	ep, err := New(&packetRW{}, 1500, 4)
+	if err != nil {
+		b.Fatal(err)
+	}
+	payload := newIPv4Packet(1400)
+	pkts := make([]*stack.PacketBuffer, 1)
+	bufs := make([][]byte, 1)
+	b.ReportAllocs()
+	b.SetBytes(1400)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pkts[0] = stack.NewPacketBuffer(stack.PacketBufferOptions{
+			ReserveHeaderBytes: 40,
+			Payload:            buffer.MakeWithData(payload[40:]),
+		})
+		copy(pkts[0].NetworkHeader().Push(40), payload[:40])
+		ep.writePackets(ep.queues[0], pkts, bufs)
+	}
+}
+
+func TestCopyPacket(t *testing.T) {
+	payload := newIPv4Packet(100)
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		ReserveHeaderBytes: 64,
+		Payload:            buffer.MakeWithData(payload[20:]),
+	})
+	defer pkt.DecRef()
+	copy(pkt.NetworkHeader().Push(20), payload[:20])
+
+	dst := make([]byte, pkt.Size())
+	if n := copyPacket(dst, pkt); n != len(payload) || !bytes.Equal(dst, payload) {
+		t.Fatalf("copyPacket = %d, %x, want %x", n, dst, payload)
+	}
+}
+
+func udpPacket(src, dst tcpip.Address, srcPort, dstPort uint16) *stack.PacketBuffer {
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		ReserveHeaderBytes: header.IPv4MinimumSize + header.UDPMinimumSize,
+	})
+	header.UDP(pkt.TransportHeader().Push(header.UDPMinimumSize)).Encode(&header.UDPFields{
+		SrcPort: srcPort,
+		DstPort: dstPort,
+		Length:  header.UDPMinimumSize,
+	})
+	header.IPv4(pkt.NetworkHeader().Push(header.IPv4MinimumSize)).Encode(&header.IPv4Fields{
+		TotalLength: header.IPv4MinimumSize + header.UDPMinimumSize,
+		Protocol:    uint8(header.UDPProtocolNumber),
+		TTL:         64,
+		SrcAddr:     src,
+		DstAddr:     dst,
+	})
+	return pkt
+}
+
+func TestFlowAffinity(t *testing.T) {
+	rws := []*batchRW{newBatchRW(), newBatchRW()}
+	ep, err := NewMultiQueue([]io.ReadWriter{rws[0], rws[1]}, 1500, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := tcpip.AddrFrom4([4]byte{198, 18, 0, 1})
+	dst := tcpip.AddrFrom4([4]byte{198, 18, 0, 2})
+	flows := map[uint32]int{}
+	for port := uint16(1000); port < 1064; port++ {
+		pkt := udpPacket(src, dst, 53, port)
+		h := flowHash(pkt)
+		if again := udpPacket(src, dst, 53, port); flowHash(again) != h {
+			t.Fatalf("flow %d hashed differently", port)
+		}
+		flows[h%2]++
+		var pkts stack.PacketBufferList
+		pkts.PushBack(pkt)
+		if n, err := ep.WritePackets(pkts); err != nil || n != 1 {
+			t.Fatalf("WritePackets = %d, %v", n, err)
+		}
+		pkts.DecRef()
+	}
+	if flows[0] == 0 || flows[1] == 0 {
+		t.Errorf("flows are not spread over queues: %v", flows)
+	}
+	for i, q := range ep.queues {
+		if len(q.out) != flows[uint32(i)] {
+			t.Errorf("queue %d has %d packets, want %d", i, len(q.out), flows[uint32(i)])
+		}
+	}
+
+	ep.Close()
+	var pkts stack.PacketBufferList
+	pkts.PushBack(udpPacket(src, dst, 53, 1000))
+	if _, err := ep.WritePackets(pkts); err == nil {
+		t.Error("WritePackets succeeded after Close")
+	}
+	pkts.DecRef()
+}
@@ -0,0 +1,91 @@
+package tun
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+const cloneDevicePath = "/dev/net/tun"
+
+// createTUN creates the TUN device with the given number of queues.
+// IFF_VNET_HDR is always set by tun.CreateTUN, so the device without
+// offload or with multiple queues is created from the files opened here.
+func createTUN(name string, mtu int, offload bool, queues int) ([]tun.Device, error) {
+	if offload && queues <= 1 {
+		dev, err := tun.CreateTUN(name, mtu)
+		if err != nil {
+			return nil, err
+		}
+		return []tun.Device{dev}, nil
+	}
+
+	flags := uint16(unix.IFF_TUN | unix.IFF_NO_PI)
+	if offload {
+		flags |= unix.IFF_VNET_HDR
+	}
+	if queues > 1 {
+		flags |= unix.IFF_MULTI_QUEUE
+	}
+
+	var devs []tun.Device
+	closeAll := func() {
+		for _, dev := range devs {
+			dev.Close()
+		}
+	}
+	for i := 0; i < max(queues, 1); i++ {
+		nfd, ifName, err := openQueue(name, flags)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		// the kernel may name the device from a pattern like "tun%d", the
+		// other queues must attach to the same device
+		name = ifName
+
+		var dev tun.Device
+		if i == 0 {
+			dev, err = tun.CreateTUNFromFile(os.NewFile(uintptr(nfd), cloneDevicePath), mtu)
+		} else {
+			// the device is monitored by the first queue
+			dev, _, err = tun.CreateUnmonitoredTUNFromFD(nfd)
+		}
+		if err != nil {
+			unix.Close(nfd)
+			closeAll()
+			return nil, err
+		}
+		devs = append(devs, dev)
+	}
+	return devs, nil
+}
+
+// openQueue opens a queue of the TUN device, and returns its fd and the
+// name of the device.
+func openQueue(name string, flags uint16) (int, string, error) {
+	nfd, err := unix.Open(cloneDevicePath, unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, "", fmt.Errorf("open %s: %w", cloneDevicePath, err)
+	}
+
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		unix.Close(nfd)
+		return -1, "", err
+	}
+	ifr.SetUint16(flags)
+	if err = unix.IoctlIfreq(nfd, unix.TUNSETIFF, ifr); err != nil {
+		unix.Close(nfd)
+		return -1, "", err
+	}
+
+	// the fd must be non-blocking before it's handed to netpoll
+	if err = unix.SetNonblock(nfd, true); err != nil {
+		unix.Close(nfd)
+		return -1, "", err
+	}
+	return nfd, ifr.Name(), nil
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package tun
+
+import (
+	"errors"
+
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// createTUN creates the TUN device, offload and multiple queues are
+// unsupported on this platform.
+func createTUN(name string, mtu int, _ bool, queues int) ([]tun.Device, error) {
+	if queues > 1 {
+		return nil, errors.New("multiple queues are only supported on Linux")
+	}
+	dev, err := tun.CreateTUN(name, mtu)
+	if err != nil {
+		return nil, err
+	}
+	return []tun.Device{dev}, nil
+}
@@ -0,0 +1,44 @@
+package tun
+
+// Option configures the TUN device opened by Open.
+type Option func(*options)
+
+type options struct {
+	offload    bool
+	queues     int
+	packetInfo bool
+}
+
+func defaultOptions() options {
+	return options{offload: true, queues: 1}
+}
+
+// WithOffload enables or disables the TCP/UDP segmentation offload, which
+// is enabled by default. It's only supported on Linux with IFF_VNET_HDR, a
+// single read then carries up to 64KB of coalesced segments, and the
+// consecutive segments in a batch are coalesced into a single write.
+func WithOffload(enabled bool) Option {
+	return func(o *options) {
+		o.offload = enabled
+	}
+}
+
+// WithQueues sets the number of queues of the device, which is 1 by
+// default. It's only supported on Linux with IFF_MULTI_QUEUE, each queue
+// is read and written by its own goroutines, and the packets of a flow are
+// always written to the same queue.
+func WithQueues(n int) Option {
+	return func(o *options) {
+		o.queues = max(n, 1)
+	}
+}
+
+// WithPacketInfo sets whether the packets of the file descriptor opened by
+// OpenFD have the 4 bytes packet information header in front of them, i.e.
+// the Linux TUN device without IFF_NO_PI or the BSD utun device. It's
+// disabled by default.
+func WithPacketInfo(enabled bool) Option {
+	return func(o *options) {
+		o.packetInfo = enabled
+	}
+}
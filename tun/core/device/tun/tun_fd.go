@@ -0,0 +1,112 @@
+//go:build unix
+
+package tun
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/josexy/netstackgo/tun/core/device"
+	"github.com/josexy/netstackgo/tun/core/device/iobased"
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// packetInfoLen is the size of the packet information header.
+const packetInfoLen = 4
+
+// FD is the device of an inherited file descriptor, each read and write of
+// which carries a single packet, e.g. a TUN device or a datagram socket.
+type FD struct {
+	*iobased.Endpoint
+
+	file   *os.File
+	name   string
+	offset int
+	wMutex sync.Mutex
+}
+
+// OpenFD opens the device from the inherited file descriptor fd, e.g. the
+// TUN device created by the Android VpnService or passed by a privileged
+// process. The device takes the ownership of fd and closes it on Close.
+//
+// A Linux TUN device without the packet information header is opened with
+// wireguard-go, so that the offload enabled by its creator is supported.
+// Otherwise fd is read and written a packet per call, and the packet
+// information header is expected if WithPacketInfo is enabled. The MTU of a
+// TUN device is read from it if mtu is 0.
+func OpenFD(fd int, mtu uint32, opts ...Option) (_ device.Device, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("open fd: %v", r)
+		}
+	}()
+
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if t, err := openTUNFD(fd, mtu, o); t != nil || err != nil {
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+
+	if mtu == 0 {
+		mtu = defaultMTU
+	}
+
+	// the fd must be non-blocking before it's handed to netpoll
+	if err = unix.SetNonblock(fd, true); err != nil {
+		return nil, fmt.Errorf("set nonblock: %w", err)
+	}
+
+	d := &FD{
+		name: fmt.Sprintf("fd%d", fd),
+	}
+	if o.packetInfo {
+		d.offset = packetInfoLen
+	}
+	d.file = os.NewFile(uintptr(fd), d.name)
+
+	ep, err := iobased.New(d, mtu, d.offset)
+	if err != nil {
+		return nil, fmt.Errorf("create endpoint: %w", err)
+	}
+	d.Endpoint = ep
+
+	return d, nil
+}
+
+// Read reads a packet into packet after the packet information header,
+// the packets without the header are ignored.
+func (d *FD) Read(packet []byte) (int, error) {
+	n, err := d.file.Read(packet)
+	if err != nil || n < d.offset {
+		return 0, err
+	}
+	return n - d.offset, nil
+}
+
+// Write writes a packet with the packet information header in front of
+// it.
+func (d *FD) Write(packet []byte) (int, error) {
+	if d.offset > 0 {
+		putPacketInfo(packet[:d.offset], header.IPVersion(packet[d.offset:]))
+	}
+	d.wMutex.Lock()
+	defer d.wMutex.Unlock()
+	return d.file.Write(packet)
+}
+
+func (d *FD) Name() string {
+	return d.name
+}
+
+func (d *FD) Close() error {
+	defer d.Endpoint.Close()
+	return d.file.Close()
+}
@@ -0,0 +1,51 @@
+package tun
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/josexy/netstackgo/tun/core/device"
+	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/tun"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// openTUNFD opens fd with wireguard-go if it's a TUN device without the
+// packet information header, or returns nil.
+func openTUNFD(fd int, mtu uint32, o options) (device.Device, error) {
+	ifr, err := unix.NewIfreq("")
+	if err != nil {
+		return nil, err
+	}
+	if err = unix.IoctlIfreq(fd, unix.TUNGETIFF, ifr); err != nil {
+		return nil, nil /* not a TUN device */
+	}
+	hasPacketInfo := ifr.Uint16()&unix.IFF_NO_PI == 0
+	if hasPacketInfo != o.packetInfo {
+		return nil, errors.New("packet information header mismatches the TUN device flags")
+	}
+	if hasPacketInfo {
+		return nil, nil
+	}
+
+	dev, name, err := tun.CreateUnmonitoredTUNFromFD(fd)
+	if err != nil {
+		return nil, err
+	}
+	t, err := newTUN(name, mtu, []tun.Device{dev}, o)
+	if err != nil {
+		dev.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// putPacketInfo puts the flags and the ethertype of a packet.
+func putPacketInfo(hdr []byte, version int) {
+	proto := header.IPv4ProtocolNumber
+	if version == header.IPv6Version {
+		proto = header.IPv6ProtocolNumber
+	}
+	binary.BigEndian.PutUint16(hdr, 0)
+	binary.BigEndian.PutUint16(hdr[2:], uint16(proto))
+}
@@ -0,0 +1,97 @@
+package tun
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+type dispatcher chan []byte
+
+func (d dispatcher) DeliverNetworkPacket(_ tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	d <- pkt.ToView().AsSlice()
+}
+
+func (dispatcher) DeliverLinkPacket(tcpip.NetworkProtocolNumber, *stack.PacketBuffer) {}
+
+func ipv4Packet() []byte {
+	packet := make([]byte, header.IPv4MinimumSize)
+	header.IPv4(packet).Encode(&header.IPv4Fields{
+		TotalLength: header.IPv4MinimumSize,
+		Protocol:    uint8(header.UDPProtocolNumber),
+		TTL:         64,
+		SrcAddr:     tcpip.AddrFrom4([4]byte{198, 18, 0, 1}),
+		DstAddr:     tcpip.AddrFrom4([4]byte{198, 18, 0, 2}),
+	})
+	return packet
+}
+
+func TestOpenFD(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		packetInfo bool
+		header     []byte
+	}{
+		{name: "raw"},
+		{name: "packet info", packetInfo: true, header: []byte{0, 0, 0x08, 0x00}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			peer := os.NewFile(uintptr(fds[1]), "peer")
+			defer peer.Close()
+
+			dev, err := OpenFD(fds[0], 1500, WithPacketInfo(tt.packetInfo))
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := make(dispatcher, 1)
+			dev.Attach(d)
+
+			packet := ipv4Packet()
+			if _, err = peer.Write(append(bytes.Clone(tt.header), packet...)); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case got := <-d:
+				if !bytes.Equal(got, packet) {
+					t.Errorf("read packet %x, want %x", got, packet)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("no packet read")
+			}
+
+			var pkts stack.PacketBufferList
+			pkts.PushBack(stack.NewPacketBuffer(stack.PacketBufferOptions{
+				Payload: buffer.MakeWithData(packet),
+			}))
+			if n, err := dev.WritePackets(pkts); err != nil || n != 1 {
+				t.Fatalf("WritePackets = %d, %v", n, err)
+			}
+			pkts.DecRef()
+
+			buf := make([]byte, 1500)
+			n, err := peer.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := append(bytes.Clone(tt.header), packet...); !bytes.Equal(buf[:n], want) {
+				t.Errorf("written packet %x, want %x", buf[:n], want)
+			}
+
+			if err = dev.Close(); err != nil {
+				t.Fatal(err)
+			}
+			dev.Wait()
+		})
+	}
+}
@@ -0,0 +1,25 @@
+//go:build unix && !linux
+
+package tun
+
+import (
+	"encoding/binary"
+
+	"github.com/josexy/netstackgo/tun/core/device"
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// openTUNFD returns nil, fd is always read and written a packet per call.
+func openTUNFD(int, uint32, options) (device.Device, error) {
+	return nil, nil
+}
+
+// putPacketInfo puts the address family of a packet.
+func putPacketInfo(hdr []byte, version int) {
+	family := uint32(unix.AF_INET)
+	if version == header.IPv6Version {
+		family = unix.AF_INET6
+	}
+	binary.BigEndian.PutUint32(hdr, family)
+}
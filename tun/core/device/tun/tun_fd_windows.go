@@ -0,0 +1,12 @@
+package tun
+
+import (
+	"errors"
+
+	"github.com/josexy/netstackgo/tun/core/device"
+)
+
+// OpenFD is unsupported on Windows.
+func OpenFD(int, uint32, ...Option) (device.Device, error) {
+	return nil, errors.New("opening a file descriptor is unsupported on Windows")
+}
@@ -1,7 +1,9 @@
 package tun
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"sync"
 
 	"github.com/josexy/netstackgo/tun/core/device"
@@ -12,11 +14,22 @@ import (
 type TUN struct {
 	*iobased.Endpoint
 
-	nt     *tun.NativeTun
+	// queue is the first queue, which reads and writes the single packets.
+	*queue
+
+	queues []*queue
 	mtu    uint32
 	name   string
+}
+
+// queue is a queue of the TUN device.
+type queue struct {
+	nt     *tun.NativeTun
 	offset int
 
+	// offload is whether the segmentation offload is enabled.
+	offload bool
+
 	rSizes []int
 	rBuffs [][]byte
 	wBuffs [][]byte
@@ -24,40 +37,71 @@ type TUN struct {
 	wMutex sync.Mutex
 }
 
-func Open(name string, mtu uint32) (_ device.Device, err error) {
+// maxOffloadSize is the maximum size of a coalesced packet written to the
+// device with offload.
+const maxOffloadSize = 1<<16 - 1
+
+func Open(name string, mtu uint32, opts ...Option) (_ device.Device, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("open tun: %v", r)
 		}
 	}()
 
-	t := &TUN{
-		name:   name,
-		mtu:    mtu,
-		offset: offset,
-		rSizes: make([]int, 1),
-		rBuffs: make([][]byte, 1),
-		wBuffs: make([][]byte, 1),
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
 	}
 
 	forcedMTU := defaultMTU
-	if t.mtu > 0 {
-		forcedMTU = int(t.mtu)
+	if mtu > 0 {
+		forcedMTU = int(mtu)
 	}
 
-	nt, err := tun.CreateTUN(t.name, forcedMTU)
+	devs, err := createTUN(name, forcedMTU, o.offload, o.queues)
 	if err != nil {
 		return nil, fmt.Errorf("create tun: %w", err)
 	}
-	t.nt = nt.(*tun.NativeTun)
-
-	tunMTU, err := nt.MTU()
+	t, err := newTUN(name, 0, devs, o)
 	if err != nil {
-		return nil, fmt.Errorf("get mtu: %w", err)
+		return nil, err
+	}
+	return t, nil
+}
+
+// newTUN returns the TUN of the queues devs, the MTU is read from the
+// device if mtu is 0.
+func newTUN(name string, mtu uint32, devs []tun.Device, o options) (*TUN, error) {
+	t := &TUN{
+		name: name,
+		mtu:  mtu,
 	}
-	t.mtu = uint32(tunMTU)
 
-	ep, err := iobased.New(t, t.mtu, offset)
+	rws := make([]io.ReadWriter, 0, len(devs))
+	for _, dev := range devs {
+		q := &queue{
+			nt:     dev.(*tun.NativeTun),
+			offset: offset,
+			// the batch size is greater than 1 only if IFF_VNET_HDR is enabled
+			offload: o.offload && dev.BatchSize() > 1,
+			rSizes:  make([]int, 1),
+			rBuffs:  make([][]byte, 1),
+			wBuffs:  make([][]byte, 1),
+		}
+		t.queues = append(t.queues, q)
+		rws = append(rws, q)
+	}
+	t.queue = t.queues[0]
+
+	if t.mtu == 0 {
+		tunMTU, err := t.nt.MTU()
+		if err != nil {
+			return nil, fmt.Errorf("get mtu: %w", err)
+		}
+		t.mtu = uint32(tunMTU)
+	}
+
+	ep, err := iobased.NewMultiQueue(rws, t.mtu, offset)
 	if err != nil {
 		return nil, fmt.Errorf("create endpoint: %w", err)
 	}
@@ -66,19 +110,48 @@ func Open(name string, mtu uint32) (_ device.Device, err error) {
 	return t, nil
 }
 
-func (t *TUN) Read(packet []byte) (int, error) {
-	t.rMutex.Lock()
-	defer t.rMutex.Unlock()
-	t.rBuffs[0] = packet
-	_, err := t.nt.Read(t.rBuffs, t.rSizes, t.offset)
-	return t.rSizes[0], err
+func (q *queue) Read(packet []byte) (int, error) {
+	q.rMutex.Lock()
+	defer q.rMutex.Unlock()
+	q.rBuffs[0] = packet
+	_, err := q.nt.Read(q.rBuffs, q.rSizes, q.offset)
+	return q.rSizes[0], err
 }
 
-func (t *TUN) Write(packet []byte) (int, error) {
-	t.wMutex.Lock()
-	defer t.wMutex.Unlock()
-	t.wBuffs[0] = packet
-	return t.nt.Write(t.wBuffs, t.offset)
+func (q *queue) Write(packet []byte) (int, error) {
+	q.wMutex.Lock()
+	defer q.wMutex.Unlock()
+	q.wBuffs[0] = packet
+	return q.nt.Write(q.wBuffs, q.offset)
+}
+
+// BatchSize implements iobased.BatchReadWriter.
+func (q *queue) BatchSize() int {
+	return q.nt.BatchSize()
+}
+
+// ReadBatch implements iobased.BatchReadWriter.
+func (q *queue) ReadBatch(bufs [][]byte, sizes []int, offset int) (int, error) {
+	n, err := q.nt.Read(bufs, sizes, offset)
+	if errors.Is(err, tun.ErrTooManySegments) {
+		// gsoSplit fills every buffer in bufs before giving up, so all of
+		// them hold valid segments; only the ones that did not fit are lost
+		return len(bufs), nil
+	}
+	return n, err
+}
+
+// WriteBatch implements iobased.BatchReadWriter.
+func (q *queue) WriteBatch(bufs [][]byte, offset int) (int, error) {
+	return q.nt.Write(bufs, offset)
+}
+
+// OffloadSize implements iobased.Offloader.
+func (q *queue) OffloadSize() int {
+	if q.offload {
+		return maxOffloadSize
+	}
+	return 0
 }
 
 func (t *TUN) Name() string {
@@ -88,5 +161,9 @@ func (t *TUN) Name() string {
 
 func (t *TUN) Close() error {
 	defer t.Endpoint.Close()
-	return t.nt.Close()
+	var errs []error
+	for _, q := range t.queues {
+		errs = append(errs, q.nt.Close())
+	}
+	return errors.Join(errs...)
 }
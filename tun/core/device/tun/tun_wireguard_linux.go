@@ -0,0 +1,6 @@
+package tun
+
+const (
+	offset     = 10 /* 10 bytes virtio_net_hdr, IFF_VNET_HDR is enabled by wireguard-go */
+	defaultMTU = 1500
+)
@@ -2,6 +2,7 @@ package core
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/josexy/netstackgo/tun/core/option"
 	"gvisor.dev/gvisor/pkg/tcpip"
@@ -55,3 +56,14 @@ func WithSpoofing(nicID tcpip.NICID, v bool) option.Option {
 		return nil
 	}
 }
+
+// WithGRO sets the GRO timeout of the given NIC, the inbound TCP segments
+// are coalesced for at most timeout before being processed.
+func WithGRO(nicID tcpip.NICID, timeout time.Duration) option.Option {
+	return func(s *stack.Stack) error {
+		if err := s.SetGROTimeout(nicID, timeout); err != nil {
+			return fmt.Errorf("set GRO timeout: %s", err)
+		}
+		return nil
+	}
+}
@@ -1,6 +1,8 @@
 package core
 
 import (
+	"errors"
+	"net"
 	"time"
 
 	"github.com/josexy/netstackgo/tun/core/adapter"
@@ -39,7 +41,15 @@ const (
 	tcpKeepaliveInterval = 30 * time.Second
 )
 
-func WithTCPHandler(handle func(adapter.TCPConn)) option.Option {
+var congestionStates = map[tcpip.CongestionControlState]string{
+	tcpip.Open:         "Open",
+	tcpip.RTORecovery:  "RTORecovery",
+	tcpip.FastRecovery: "FastRecovery",
+	tcpip.SACKRecovery: "SACKRecovery",
+	tcpip.Disorder:     "Disorder",
+}
+
+func WithTCPHandler(handle func(adapter.TCPConn), onError adapter.ErrorHandler) option.Option {
 	return func(s *stack.Stack) error {
 		tcpForwarder := tcp.NewForwarder(s, defaultWndSize, maxConnAttempts, func(r *tcp.ForwarderRequest) {
 			var (
@@ -54,14 +64,18 @@ func WithTCPHandler(handle func(adapter.TCPConn)) option.Option {
 			if err != nil {
 				// RST: prevent potential half-open TCP connection leak.
 				r.Complete(true)
+				reportError(onError, adapter.ErrorCreateEndpoint, &id, err)
 				return
 			}
 			defer r.Complete(false)
 
-			err = setSocketOptions(s, ep)
+			if err = setSocketOptions(s, ep); err != nil {
+				reportError(onError, adapter.ErrorSocketOptions, &id, err)
+			}
 
 			conn := &tcpConn{
 				TCPConn: gonet.NewTCPConn(&wq, ep),
+				ep:      ep,
 				id:      id,
 			}
 			handle(conn)
@@ -71,6 +85,13 @@ func WithTCPHandler(handle func(adapter.TCPConn)) option.Option {
 	}
 }
 
+// reportError reports the tcpip.Error to onError if it's set.
+func reportError(onError adapter.ErrorHandler, kind adapter.ErrorKind, id *stack.TransportEndpointID, err tcpip.Error) {
+	if onError != nil {
+		onError(kind, id, errors.New(err.String()))
+	}
+}
+
 func setSocketOptions(s *stack.Stack, ep tcpip.Endpoint) tcpip.Error {
 	{ /* TCP keepalive options */
 		ep.SocketOptions().SetKeepAlive(true)
@@ -92,7 +113,7 @@ func setSocketOptions(s *stack.Stack, ep tcpip.Endpoint) tcpip.Error {
 	{ /* TCP recv/send buffer size */
 		var ss tcpip.TCPSendBufferSizeRangeOption
 		if err := s.TransportProtocolOption(header.TCPProtocolNumber, &ss); err == nil {
-			ep.SocketOptions().SetReceiveBufferSize(int64(ss.Default), false)
+			ep.SocketOptions().SetSendBufferSize(int64(ss.Default), false)
 		}
 
 		var rs tcpip.TCPReceiveBufferSizeRangeOption
@@ -105,9 +126,85 @@ func setSocketOptions(s *stack.Stack, ep tcpip.Endpoint) tcpip.Error {
 
 type tcpConn struct {
 	*gonet.TCPConn
+	ep tcpip.Endpoint
 	id stack.TransportEndpointID
 }
 
 func (c *tcpConn) ID() *stack.TransportEndpointID {
 	return &c.id
 }
+
+func (c *tcpConn) Abort() error {
+	c.ep.SocketOptions().SetLinger(tcpip.LingerOption{Enabled: true, Timeout: 0})
+	return c.TCPConn.Close()
+}
+
+func (c *tcpConn) SetNoDelay(noDelay bool) error {
+	c.ep.SocketOptions().SetDelayOption(!noDelay)
+	return nil
+}
+
+func (c *tcpConn) SetKeepAlive(keepalive bool) error {
+	c.ep.SocketOptions().SetKeepAlive(keepalive)
+	return nil
+}
+
+func (c *tcpConn) SetKeepAlivePeriod(d time.Duration) error {
+	idle := tcpip.KeepaliveIdleOption(d)
+	if err := c.ep.SetSockOpt(&idle); err != nil {
+		return c.opError("set keepalive idle", err)
+	}
+	interval := tcpip.KeepaliveIntervalOption(d)
+	if err := c.ep.SetSockOpt(&interval); err != nil {
+		return c.opError("set keepalive interval", err)
+	}
+	return nil
+}
+
+func (c *tcpConn) SetReadBuffer(bytes int) error {
+	c.ep.SocketOptions().SetReceiveBufferSize(int64(bytes), true)
+	return nil
+}
+
+func (c *tcpConn) SetWriteBuffer(bytes int) error {
+	c.ep.SocketOptions().SetSendBufferSize(int64(bytes), true)
+	return nil
+}
+
+func (c *tcpConn) TCPInfo() (adapter.TCPInfo, error) {
+	var info tcpip.TCPInfoOption
+	if err := c.ep.GetSockOpt(&info); err != nil {
+		return adapter.TCPInfo{}, c.opError("get tcp info", err)
+	}
+	tcpInfo := adapter.TCPInfo{
+		State:              tcp.EndpointState(info.State).String(),
+		CongestionState:    congestionStates[info.CcState],
+		RTT:                info.RTT,
+		RTTVar:             info.RTTVar,
+		RTO:                info.RTO,
+		CongestionWindow:   info.SndCwnd,
+		SlowStartThreshold: info.SndSsthresh,
+		ReorderSeen:        info.ReorderSeen,
+	}
+	if stats, ok := c.ep.Stats().(*tcp.Stats); ok {
+		tcpInfo.SegmentsSent = stats.SegmentsSent.Value()
+		tcpInfo.SegmentsReceived = stats.SegmentsReceived.Value()
+		tcpInfo.Retransmits = stats.SendErrors.Retransmits.Value()
+		tcpInfo.FastRetransmits = stats.SendErrors.FastRetransmit.Value()
+		tcpInfo.Timeouts = stats.SendErrors.Timeouts.Value()
+	}
+	// the queue sizes are unavailable in some states, e.g. after close
+	tcpInfo.SendQueueSize, _ = c.ep.GetSockOptInt(tcpip.SendQueueSizeOption)
+	tcpInfo.ReceiveQueueSize, _ = c.ep.GetSockOptInt(tcpip.ReceiveQueueSizeOption)
+	return tcpInfo, nil
+}
+
+func (c *tcpConn) opError(op string, err tcpip.Error) error {
+	return &net.OpError{
+		Op:     op,
+		Net:    "tcp",
+		Source: c.LocalAddr(),
+		Addr:   c.RemoteAddr(),
+		Err:    errors.New(err.String()),
+	}
+}
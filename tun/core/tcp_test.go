@@ -0,0 +1,164 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/josexy/netstackgo/tun/core/adapter"
+	"github.com/josexy/netstackgo/tun/core/device/generic"
+	"github.com/josexy/netstackgo/tun/core/option"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+)
+
+var (
+	clientAddr = tcpip.AddrFrom4([4]byte{10, 0, 0, 2})
+	serverAddr = tcpip.AddrFrom4([4]byte{10, 0, 0, 1})
+)
+
+// newTestStack creates a stack on the framed device over rwc with the
+// options opts applied before the NIC is created.
+func newTestStack(t *testing.T, rwc io.ReadWriteCloser, opts ...option.Option) *stack.Stack {
+	dev, err := generic.NewFramed("pipe", rwc, 1500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol},
+	})
+	t.Cleanup(func() {
+		dev.Close()
+		s.Close()
+		s.Wait()
+	})
+	opts = append([]option.Option{option.WithDefault()}, opts...)
+	opts = append(opts,
+		WithCreatingNIC(1, dev),
+		WithPromiscuousMode(1, NicPromiscuousModeEnabled),
+		WithSpoofing(1, NicSpoofingEnabled),
+		WithRouteTable(1),
+	)
+	for _, opt := range opts {
+		if err = opt(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+// dialForwarded connects a client stack to the stack forwarding TCP with
+// WithTCPHandler, and returns both ends of the connection.
+func dialForwarded(t *testing.T) (net.Conn, adapter.TCPConn) {
+	local, remote := net.Pipe()
+	conns := make(chan adapter.TCPConn, 1)
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	newTestStack(t, local,
+		WithTCPHandler(func(conn adapter.TCPConn) {
+			conns <- conn
+			// the forwarder completes the request when the handler returns
+			<-done
+		}, nil),
+	)
+	s := newTestStack(t, remote)
+	if err := s.AddProtocolAddress(1, tcpip.ProtocolAddress{
+		Protocol:          ipv4.ProtocolNumber,
+		AddressWithPrefix: clientAddr.WithPrefix(),
+	}, stack.AddressProperties{}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := gonet.DialContextTCP(ctx, s, tcpip.FullAddress{NIC: 1, Addr: serverAddr, Port: 80}, ipv4.ProtocolNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	select {
+	case conn := <-conns:
+		t.Cleanup(func() { conn.Close() })
+		return client, conn
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection was not forwarded")
+		return nil, nil
+	}
+}
+
+func TestTCPConnAbort(t *testing.T) {
+	client, conn := dialForwarded(t)
+	if err := conn.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err := client.Read(make([]byte, 1))
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || opErr.Err.Error() != (&tcpip.ErrConnectionReset{}).String() {
+		t.Fatalf("expected RST, got %v", err)
+	}
+}
+
+func TestTCPConnCloseWrite(t *testing.T) {
+	client, conn := dialForwarded(t)
+	if err := conn.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected FIN, got %v", err)
+	}
+
+	// the other direction is still open
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Fatalf("unexpected data %q", buf)
+	}
+}
+
+func TestTCPConnSocketOptions(t *testing.T) {
+	_, conn := dialForwarded(t)
+	ep := conn.(*tcpConn).ep
+	if err := conn.SetNoDelay(true); err != nil {
+		t.Fatal(err)
+	}
+	if ep.SocketOptions().GetDelayOption() {
+		t.Error("Nagle's algorithm is still enabled")
+	}
+	if err := conn.SetKeepAlive(false); err != nil {
+		t.Fatal(err)
+	}
+	if ep.SocketOptions().GetKeepAlive() {
+		t.Error("keep-alive is still enabled")
+	}
+	if err := conn.SetKeepAlivePeriod(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	var idle tcpip.KeepaliveIdleOption
+	if err := ep.GetSockOpt(&idle); err != nil || time.Duration(idle) != 10*time.Second {
+		t.Errorf("unexpected keepalive idle %v, %v", time.Duration(idle), err)
+	}
+	info, err := conn.TCPInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.State != "ESTABLISHED" || info.SegmentsReceived == 0 {
+		t.Errorf("unexpected tcp info %+v", info)
+	}
+}
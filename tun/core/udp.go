@@ -9,7 +9,7 @@ import (
 	"gvisor.dev/gvisor/pkg/waiter"
 )
 
-func WithUDPHandler(handle func(adapter.UDPConn)) option.Option {
+func WithUDPHandler(handle func(adapter.UDPConn), onError adapter.ErrorHandler) option.Option {
 	return func(s *stack.Stack) error {
 		udpForwarder := udp.NewForwarder(s, func(r *udp.ForwarderRequest) {
 			var (
@@ -18,6 +18,7 @@ func WithUDPHandler(handle func(adapter.UDPConn)) option.Option {
 			)
 			ep, err := r.CreateEndpoint(&wq)
 			if err != nil {
+				reportError(onError, adapter.ErrorCreateEndpoint, &id, err)
 				return
 			}
 			conn := &udpConn{
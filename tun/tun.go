@@ -18,6 +18,16 @@ type TunConfig struct {
 	Name string
 	Addr string
 	MTU  uint32
+	// DisableOffload disables the TCP/UDP segmentation offload, which is
+	// only supported on Linux and enabled by default.
+	DisableOffload bool
+	// Queues is the number of queues of the TUN device, each is read and
+	// written in parallel. Multiple queues are only supported on Linux.
+	Queues int
+	// SkipSetup skips setting up the address and the routes of the TUN
+	// device on the host, e.g. they are set up by the creator of the
+	// device opened from an inherited file descriptor.
+	SkipSetup bool
 }
 
 func exeCmd(cmd string) error {
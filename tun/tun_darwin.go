@@ -29,3 +29,16 @@ func AddTunRoutes(name string, routes []IPRoute) error {
 	}
 	return nil
 }
+
+func DelTunRoutes(name string, routes []IPRoute) error {
+	for _, route := range routes {
+		if !route.Dest.IsValid() && !route.Gateway.IsValid() {
+			continue
+		}
+		cmd := fmt.Sprintf("route delete -net %s %s", route.Dest.String(), route.Gateway)
+		if err := exeCmd(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -31,3 +31,16 @@ func AddTunRoutes(name string, routes []IPRoute) error {
 	}
 	return nil
 }
+
+func DelTunRoutes(name string, routes []IPRoute) error {
+	for _, route := range routes {
+		if !route.Dest.IsValid() && !route.Gateway.IsValid() {
+			continue
+		}
+		cmd := fmt.Sprintf("ip route del %s via %s dev %s", route.Dest.String(), route.Gateway.String(), name)
+		if err := exeCmd(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -31,3 +31,20 @@ func AddTunRoutes(name string, routes []IPRoute) error {
 	}
 	return nil
 }
+
+func DelTunRoutes(name string, routes []IPRoute) error {
+	for _, route := range routes {
+		if !route.Dest.IsValid() && !route.Gateway.IsValid() {
+			continue
+		}
+		cmd := fmt.Sprintf("netsh interface ipv4 delete route %s \"%s\" %s store=active",
+			route.Dest.String(),
+			name,
+			route.Gateway.String(),
+		)
+		if err := exeCmd(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package netstackgo
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+)
+
+var errNotRunning = errors.New("tun netstack is not running")
+
+// ListenVirtualTCP listens on the virtual address addr inside the netstack,
+// e.g. 198.18.0.1:80. The TCP connections from the TUN clients to addr are
+// accepted by the returned listener instead of being forwarded to the
+// ConnHandler. It must be called after Start.
+func (ns *TunNetstack) ListenVirtualTCP(addr netip.AddrPort) (net.Listener, error) {
+	if !ns.running {
+		return nil, errNotRunning
+	}
+	fullAddr, proto := fullAddress(addr)
+	return gonet.ListenTCP(ns.netstack, fullAddr, proto)
+}
+
+// ListenVirtualUDP binds the virtual address addr inside the netstack, e.g.
+// 10.0.0.53:53. The UDP packets from the TUN clients to addr are read from
+// the returned conn instead of being forwarded to the ConnHandler. It must be
+// called after Start.
+func (ns *TunNetstack) ListenVirtualUDP(addr netip.AddrPort) (net.PacketConn, error) {
+	if !ns.running {
+		return nil, errNotRunning
+	}
+	fullAddr, proto := fullAddress(addr)
+	return gonet.DialUDP(ns.netstack, &fullAddr, nil, proto)
+}
+
+func fullAddress(addr netip.AddrPort) (tcpip.FullAddress, tcpip.NetworkProtocolNumber) {
+	ip := addr.Addr().Unmap()
+	proto := ipv4.ProtocolNumber
+	if ip.Is6() {
+		proto = ipv6.ProtocolNumber
+	}
+	return tcpip.FullAddress{
+		Addr: tcpip.AddrFromSlice(ip.AsSlice()),
+		Port: addr.Port(),
+	}, proto
+}
@@ -0,0 +1,138 @@
+package netstackgo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/josexy/netstackgo/tun"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+)
+
+// notifyHandler is a ConnHandler sending the destination of each forwarded
+// connection to its channel.
+type notifyHandler chan netip.AddrPort
+
+func (h notifyHandler) HandleTCPConn(connTuple ConnTuple, conn net.Conn) {
+	h <- connTuple.DstAddr
+	conn.Close()
+}
+
+func (h notifyHandler) HandleUDPConn(connTuple ConnTuple, conn net.PacketConn) {
+	h <- connTuple.DstAddr
+	conn.Close()
+}
+
+func TestListenVirtualTCP(t *testing.T) {
+	ns, client := startTestNetstack(t)
+	forwarded := make(notifyHandler, 1)
+	ns.RegisterConnHandler(forwarded)
+
+	// the address isn't owned by the netstack
+	ln, err := ns.ListenVirtualTCP(netip.MustParseAddrPort("10.1.2.3:80"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.RemoteAddr)
+	}))
+
+	want := testClientAddr.String()
+	if host, _, _ := net.SplitHostPort(httpGet(t, client, "http://10.1.2.3/")); host != want {
+		t.Fatalf("unexpected remote address %q, want %s", host, want)
+	}
+	select {
+	case dst := <-forwarded:
+		t.Fatalf("the connection to %s was forwarded", dst)
+	default:
+	}
+
+	// the other ports of the address are still forwarded
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := gonet.DialContextTCP(ctx, client, tcpip.FullAddress{
+		NIC:  1,
+		Addr: tcpip.AddrFrom4([4]byte{10, 1, 2, 3}),
+		Port: 81,
+	}, ipv4.ProtocolNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	select {
+	case dst := <-forwarded:
+		if dst != netip.MustParseAddrPort("10.1.2.3:81") {
+			t.Fatalf("unexpected forwarded destination %s", dst)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection to another port was not forwarded")
+	}
+}
+
+func TestListenVirtualUDP(t *testing.T) {
+	ns, client := startTestNetstack(t)
+	forwarded := make(notifyHandler, 1)
+	ns.RegisterConnHandler(forwarded)
+
+	pc, err := ns.ListenVirtualUDP(netip.MustParseAddrPort("10.1.2.3:53"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	conn, err := gonet.DialUDP(client, nil, &tcpip.FullAddress{
+		NIC:  1,
+		Addr: tcpip.AddrFrom4([4]byte{10, 1, 2, 3}),
+		Port: 53,
+	}, ipv4.ProtocolNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	pc.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err = conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	n, from, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "ping" || from.String() != conn.LocalAddr().String() {
+		t.Fatalf("unexpected datagram %q from %s", buf[:n], from)
+	}
+	if _, err = pc.WriteTo([]byte("pong"), from); err != nil {
+		t.Fatal(err)
+	}
+	if n, err = conn.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "pong" {
+		t.Fatalf("unexpected reply %q", buf[:n])
+	}
+	select {
+	case dst := <-forwarded:
+		t.Fatalf("the session to %s was forwarded", dst)
+	default:
+	}
+}
+
+func TestListenVirtualNotRunning(t *testing.T) {
+	ns := New(tun.TunConfig{Addr: "198.18.0.1/16", MTU: 1500, SkipSetup: true})
+	if _, err := ns.ListenVirtualTCP(netip.MustParseAddrPort("10.1.2.3:80")); !errors.Is(err, errNotRunning) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := ns.ListenVirtualUDP(netip.MustParseAddrPort("10.1.2.3:53")); !errors.Is(err, errNotRunning) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}